@@ -0,0 +1,296 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+// Package commands validates business invariants and writes events. Every
+// writer appends events through this package instead of events.Insert.
+package commands
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"pocka.jp/x/event_sourcing_user_management_poc/auth"
+	"pocka.jp/x/event_sourcing_user_management_poc/events"
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/event"
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/model"
+	"pocka.jp/x/event_sourcing_user_management_poc/projections/sessions"
+	"pocka.jp/x/event_sourcing_user_management_poc/projections/users"
+)
+
+var (
+	ErrInvalidCommand = errors.New("Invalid command")
+	ErrUserNotFound   = errors.New("User not found")
+	ErrUserExists     = errors.New("User already exists")
+	ErrUserErased     = errors.New("User is erased")
+	ErrEmailUsed      = errors.New("Email is already used")
+)
+
+// Command is an operation on a user.
+type Command interface {
+	// UserID returns the ID of the user the command operates on.
+	UserID() string
+
+	// decide validates the command against the user and returns events to append.
+	decide(u *User) ([]proto.Message, error)
+}
+
+// CreateUser creates a user with an email no other user has. Emails are
+// compared after normalization (see users.NormalizeEmail).
+type CreateUser struct {
+	ID          string
+	DisplayName string
+	Email       string
+}
+
+func (c CreateUser) UserID() string {
+	return c.ID
+}
+
+func (c CreateUser) decide(u *User) ([]proto.Message, error) {
+	if c.ID == "" || c.DisplayName == "" || !strings.Contains(c.Email, "@") {
+		return nil, fmt.Errorf("%w: ID, display name and a valid email are required", ErrInvalidCommand)
+	}
+
+	if u.Created || u.Version > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrUserExists, c.ID)
+	}
+
+	return []proto.Message{
+		&event.UserCreated{
+			Id:          proto.String(c.ID),
+			DisplayName: proto.String(c.DisplayName),
+			Email:       proto.String(c.Email),
+		},
+	}, nil
+}
+
+// AssignRole assigns the role to an existing, not erased user.
+type AssignRole struct {
+	ID   string
+	Role model.Role
+}
+
+func (c AssignRole) UserID() string {
+	return c.ID
+}
+
+func (c AssignRole) decide(u *User) ([]proto.Message, error) {
+	if _, ok := model.Role_name[int32(c.Role)]; !ok || c.Role == model.Role_ROLE_UNKNOWN {
+		return nil, fmt.Errorf("%w: unknown role %d", ErrInvalidCommand, c.Role)
+	}
+
+	if !u.Created {
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, c.ID)
+	}
+
+	if u.Erased {
+		return nil, fmt.Errorf("%w: %s", ErrUserErased, c.ID)
+	}
+
+	return []proto.Message{
+		&event.RoleAssigned{
+			UserId: proto.String(c.ID),
+			Role:   c.Role.Enum(),
+		},
+	}, nil
+}
+
+// ConfigurePasswordLogin enables login with the password for an existing
+// user, replacing the previous password if any.
+type ConfigurePasswordLogin struct {
+	ID       string
+	Password string
+}
+
+func (c ConfigurePasswordLogin) UserID() string {
+	return c.ID
+}
+
+func (c ConfigurePasswordLogin) decide(u *User) ([]proto.Message, error) {
+	if c.Password == "" {
+		return nil, fmt.Errorf("%w: password is required", ErrInvalidCommand)
+	}
+
+	if !u.Created {
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, c.ID)
+	}
+
+	if u.Erased {
+		return nil, fmt.Errorf("%w: %s", ErrUserErased, c.ID)
+	}
+
+	passwordHash, salt := auth.HashPasswordWithRandomSalt(c.Password)
+
+	return []proto.Message{
+		&event.PasswordLoginConfigured{
+			UserId:       proto.String(c.ID),
+			PasswordHash: passwordHash,
+			Salt:         salt,
+		},
+	}, nil
+}
+
+// Number of attempts to run commands when another writer appends to the
+// stream in the meantime.
+const maxAttempts = 3
+
+// Handle runs the commands in order against the user they operate on, then
+// appends the resulting events to the user's stream in a single transaction,
+// along with reservations of emails of created users.
+// Every command must operate on the same user. Nothing is appended if any
+// command is rejected. Commands are validated again when another writer
+// appends to the stream in the meantime.
+func Handle(db *sql.DB, metadata events.Metadata, cmds ...Command) error {
+	if len(cmds) == 0 {
+		return nil
+	}
+
+	id := cmds[0].UserID()
+	for _, cmd := range cmds[1:] {
+		if cmd.UserID() != id {
+			return fmt.Errorf("%w: commands operate on different users", ErrInvalidCommand)
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		u, err := LoadUser(db, id)
+		if err != nil {
+			return err
+		}
+
+		version := u.Version
+
+		evs := []proto.Message{}
+		for _, cmd := range cmds {
+			decided, err := cmd.decide(u)
+			if err != nil {
+				return err
+			}
+
+			// Later commands see changes made by earlier ones.
+			for _, ev := range decided {
+				u.apply(ev)
+			}
+
+			evs = append(evs, decided...)
+		}
+
+		err = events.Transact(db, func(tx *events.Tx) error {
+			if err := reserveEmails(tx, evs); err != nil {
+				return err
+			}
+
+			return tx.InsertWithExpectedVersion(events.UserStream(id), version, metadata, evs)
+		})
+		if errors.Is(err, events.ErrConcurrencyConflict) && attempt < maxAttempts {
+			continue
+		}
+
+		return err
+	}
+}
+
+// EraseUser erases personal data of an existing user by destroying the user's
+// data key, revokes the user's sessions, and hands the user's email over to
+// the next user having it, if any. Snapshots of the users and sessions
+// projections are deleted in the same transaction, as they have the personal
+// data in plaintext. Erasing an erased user deletes the snapshots again, so a
+// failed erasure can be retried. The WAL file is truncated after the erasure,
+// so the data key does not remain in it. Fails with an error wrapping
+// events.ErrPlaintextPersonalData if events hold the user's personal data in
+// plaintext.
+func EraseUser(db *sql.DB, metadata events.Metadata, id string) error {
+	u, err := LoadUser(db, id)
+	if err != nil {
+		return err
+	}
+
+	if !u.Created {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, id)
+	}
+
+	userProjection, _, err := users.GetProjection(db)
+	if err != nil {
+		return err
+	}
+
+	sessionProjection, _, err := sessions.GetProjection(db)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	err = events.Transact(db, func(tx *events.Tx) error {
+		for _, s := range sessions.ListByUser(sessionProjection, id) {
+			if _, err := revokeSession(tx, metadata, s.GetId(), now); err != nil {
+				return err
+			}
+		}
+
+		if err := releaseEmails(tx, userProjection, id); err != nil {
+			return err
+		}
+
+		if !u.Erased {
+			if err := events.EraseUserData(tx, id, u.Version, metadata); err != nil {
+				return err
+			}
+		}
+
+		if err := users.Projection.DeleteSnapshots(tx.Tx); err != nil {
+			return err
+		}
+
+		return sessions.Projection.DeleteSnapshots(tx.Tx)
+	})
+	if err != nil {
+		return err
+	}
+
+	return truncateWAL(db)
+}
+
+// truncateWAL copies pages in the WAL file to the database file then empties
+// the WAL file, so deleted rows do not remain in old pages of the WAL file.
+func truncateWAL(db *sql.DB) error {
+	var busy, logPages, checkpointed int
+	err := db.QueryRow("PRAGMA wal_checkpoint(TRUNCATE)").Scan(&busy, &logPages, &checkpointed)
+	if err != nil {
+		return fmt.Errorf("Failed to checkpoint WAL: %s", err)
+	}
+
+	if busy != 0 {
+		return fmt.Errorf("Failed to checkpoint WAL: other connections are using it, retry later")
+	}
+
+	return nil
+}
+
+// CreateInitialAdminCreationPassword saves the hash of the one-time password
+// to create the first admin user with. Any previous password is replaced.
+func CreateInitialAdminCreationPassword(db *sql.DB, metadata events.Metadata, password string) error {
+	if password == "" {
+		return fmt.Errorf("%w: password is required", ErrInvalidCommand)
+	}
+
+	passwordHash, salt := auth.HashPasswordWithRandomSalt(password)
+
+	return events.Insert(db, events.InitialAdminCreationPasswordStream, metadata, []proto.Message{
+		&event.InitialAdminCreationPasswordCreated{
+			PasswordHash: passwordHash,
+			Salt:         salt,
+		},
+	})
+}
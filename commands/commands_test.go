@@ -0,0 +1,291 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package commands
+
+import (
+	"database/sql"
+	"errors"
+	"slices"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	_ "modernc.org/sqlite"
+
+	"pocka.jp/x/event_sourcing_user_management_poc/events"
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/event"
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/model"
+	"pocka.jp/x/event_sourcing_user_management_poc/migrations"
+	"pocka.jp/x/event_sourcing_user_management_poc/projections/users"
+)
+
+func openDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := migrations.Apply(db); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestCreateUser(t *testing.T) {
+	db := openDB(t)
+
+	if err := Handle(
+		db,
+		events.Metadata{},
+		CreateUser{ID: "foo", DisplayName: "Foo", Email: "foo@example.com"},
+		ConfigurePasswordLogin{ID: "foo", Password: "foo"},
+		AssignRole{ID: "foo", Role: model.Role_ROLE_ADMIN},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := LoadUser(db, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !u.Created || u.Version != 3 || !u.PasswordLogin || u.Role != model.Role_ROLE_ADMIN {
+		t.Errorf("Unexpected user: %+v", u)
+	}
+
+	err = Handle(db, events.Metadata{}, CreateUser{ID: "foo", DisplayName: "Foo", Email: "other@example.com"})
+	if !errors.Is(err, ErrUserExists) {
+		t.Errorf("Expected ErrUserExists, got %v", err)
+	}
+
+	err = Handle(db, events.Metadata{}, CreateUser{ID: "bar", DisplayName: "Bar", Email: " FOO@example.com"})
+	if !errors.Is(err, ErrEmailUsed) {
+		t.Errorf("Expected ErrEmailUsed, got %v", err)
+	}
+}
+
+func TestRejectedCommands(t *testing.T) {
+	db := openDB(t)
+
+	err := Handle(db, events.Metadata{}, AssignRole{ID: "foo", Role: model.Role_ROLE_ADMIN})
+	if !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+
+	// Nothing is appended when any of the commands is rejected.
+	err = Handle(
+		db,
+		events.Metadata{},
+		CreateUser{ID: "foo", DisplayName: "Foo", Email: "foo@example.com"},
+		AssignRole{ID: "foo", Role: model.Role_ROLE_UNKNOWN},
+	)
+	if !errors.Is(err, ErrInvalidCommand) {
+		t.Errorf("Expected ErrInvalidCommand, got %v", err)
+	}
+
+	if seq, err := events.LatestSeq(db); err != nil {
+		t.Fatal(err)
+	} else if seq != 0 {
+		t.Errorf("Expected no events, got seq=%d", seq)
+	}
+
+	err = Handle(
+		db,
+		events.Metadata{},
+		CreateUser{ID: "foo", DisplayName: "Foo", Email: "foo@example.com"},
+		AssignRole{ID: "bar", Role: model.Role_ROLE_ADMIN},
+	)
+	if !errors.Is(err, ErrInvalidCommand) {
+		t.Errorf("Expected ErrInvalidCommand for commands on different users, got %v", err)
+	}
+}
+
+func TestEraseUser(t *testing.T) {
+	db := openDB(t)
+
+	if err := Handle(db, events.Metadata{}, CreateUser{ID: "foo", DisplayName: "Foo", Email: "foo@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := users.Projection.SaveSnapshot(db); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := EraseUser(db, events.Metadata{}, "foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	var snapshots int
+	if err := db.QueryRow("SELECT count(*) FROM users_snapshots").Scan(&snapshots); err != nil {
+		t.Fatal(err)
+	}
+
+	if snapshots != 0 {
+		t.Errorf("Expected snapshots holding personal data to be deleted, got %d", snapshots)
+	}
+
+	// Retrying deletes snapshots again, without appending another UserErased.
+	if _, err := users.Projection.SaveSnapshot(db); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := EraseUser(db, events.Metadata{}, "foo"); err != nil {
+		t.Errorf("Expected erasing an erased user to succeed, got %v", err)
+	}
+
+	if u, err := LoadUser(db, "foo"); err != nil {
+		t.Fatal(err)
+	} else if u.Version != 2 {
+		t.Errorf("Expected version 2, got %d", u.Version)
+	}
+
+	for _, cmd := range []Command{
+		AssignRole{ID: "foo", Role: model.Role_ROLE_ADMIN},
+		ConfigurePasswordLogin{ID: "foo", Password: "foo"},
+	} {
+		if err := Handle(db, events.Metadata{}, cmd); !errors.Is(err, ErrUserErased) {
+			t.Errorf("Expected ErrUserErased for %T, got %v", cmd, err)
+		}
+	}
+
+	if err := EraseUser(db, events.Metadata{}, "bar"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+
+	// The email is free once the user is erased.
+	if err := Handle(db, events.Metadata{}, CreateUser{ID: "bar", DisplayName: "Bar", Email: "foo@example.com"}); err != nil {
+		t.Errorf("Expected the email to be reusable, got %v", err)
+	}
+}
+
+func TestLoadLegacyUser(t *testing.T) {
+	db := openDB(t)
+
+	payload, err := proto.Marshal(&event.UserCreated{Id: proto.String("foo"), Email: proto.String("foo@example.com")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Events inserted before stream support have no stream.
+	if _, err := db.Exec("INSERT INTO user_events (event_name, payload) VALUES ('UserCreated', ?)", payload); err != nil {
+		t.Fatal(err)
+	}
+
+	// Payload of the other user's event contains "foo" too.
+	payload, err = proto.Marshal(&event.RoleAssigned{UserId: proto.String("foobar"), Role: model.Role_ROLE_ADMIN.Enum()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec("INSERT INTO user_events (event_name, payload) VALUES ('RoleAssigned', ?)", payload); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Handle(db, events.Metadata{}, AssignRole{ID: "foo", Role: model.Role_ROLE_EDITOR}); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := LoadUser(db, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !u.Created || u.Version != 1 || u.Role != model.Role_ROLE_EDITOR {
+		t.Errorf("Unexpected user: %+v", u)
+	}
+}
+
+func TestEraseLegacyUser(t *testing.T) {
+	db := openDB(t)
+
+	payload, err := proto.Marshal(&event.UserCreated{Id: proto.String("foo"), Email: proto.String("foo@example.com")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Written before encryption of personal data.
+	if _, err := db.Exec("INSERT INTO user_events (event_name, payload) VALUES ('UserCreated', ?)", payload); err != nil {
+		t.Fatal(err)
+	}
+
+	err = EraseUser(db, events.Metadata{}, "foo")
+
+	var plaintext *events.PlaintextPersonalDataError
+	if !errors.As(err, &plaintext) || !slices.Equal(plaintext.Seqs, []int{1}) {
+		t.Fatalf("Expected PlaintextPersonalDataError at seq=1, got %v", err)
+	}
+
+	if u, err := LoadUser(db, "foo"); err != nil {
+		t.Fatal(err)
+	} else if u.Erased {
+		t.Error("Expected the user not to be erased")
+	}
+}
+
+func TestReserveExistingEmails(t *testing.T) {
+	db := openDB(t)
+
+	// Users created before reservations exist, with the same email.
+	for _, id := range []string{"foo", "bar"} {
+		if err := events.Insert(db, events.UserStream(id), events.Metadata{}, []proto.Message{
+			&event.UserCreated{Id: proto.String(id), DisplayName: proto.String(id), Email: proto.String("Foo@example.com")},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n, err := ReserveExistingEmails(db); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Errorf("Expected 1 reserved email, got %d", n)
+	}
+
+	var userID string
+	if err := db.QueryRow("SELECT user_id FROM email_reservations WHERE email = 'foo@example.com'").Scan(&userID); err != nil {
+		t.Fatal(err)
+	}
+
+	if userID != "foo" {
+		t.Errorf("Expected the first user to get the email, got %s", userID)
+	}
+
+	err := Handle(db, events.Metadata{}, CreateUser{ID: "baz", DisplayName: "Baz", Email: "foo@example.com"})
+	if !errors.Is(err, ErrEmailUsed) {
+		t.Errorf("Expected ErrEmailUsed, got %v", err)
+	}
+
+	// The email is handed over to the other user having it.
+	if err := EraseUser(db, events.Metadata{}, "foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.QueryRow("SELECT user_id FROM email_reservations WHERE email = 'foo@example.com'").Scan(&userID); err != nil {
+		t.Fatal(err)
+	}
+
+	if userID != "bar" {
+		t.Errorf("Expected the email to be handed over to bar, got %s", userID)
+	}
+
+	err = Handle(db, events.Metadata{}, CreateUser{ID: "baz", DisplayName: "Baz", Email: "foo@example.com"})
+	if !errors.Is(err, ErrEmailUsed) {
+		t.Errorf("Expected ErrEmailUsed after handing over, got %v", err)
+	}
+
+	if err := EraseUser(db, events.Metadata{}, "bar"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Handle(db, events.Metadata{}, CreateUser{ID: "baz", DisplayName: "Baz", Email: "foo@example.com"}); err != nil {
+		t.Errorf("Expected the email to be available once every user having it is erased, got %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package commands
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"pocka.jp/x/event_sourcing_user_management_poc/events"
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/event"
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/projection"
+	"pocka.jp/x/event_sourcing_user_management_poc/projections/users"
+)
+
+// reserveEmails reserves emails of users created by the events, for the user.
+// Returns an error wrapping ErrEmailUsed if another user has the email.
+func reserveEmails(tx *events.Tx, evs []proto.Message) error {
+	for _, ev := range evs {
+		created, ok := ev.(*event.UserCreated)
+		if !ok {
+			continue
+		}
+
+		email := users.NormalizeEmail(created.GetEmail())
+
+		res, err := tx.Exec(
+			"INSERT INTO email_reservations (email, user_id, reserved_at) VALUES (?, ?, ?)"+
+				" ON CONFLICT (email) DO NOTHING",
+			email, created.GetId(), time.Now().UnixMilli(),
+		)
+		if err != nil {
+			return fmt.Errorf("Failed to reserve email %s: %s", email, err)
+		}
+
+		if n, err := res.RowsAffected(); err != nil {
+			return fmt.Errorf("Failed to reserve email %s: %s", email, err)
+		} else if n == 0 {
+			return fmt.Errorf("%w: %s", ErrEmailUsed, email)
+		}
+	}
+
+	return nil
+}
+
+// releaseEmails hands emails of the user over to the next user having the same
+// email in the users projection, as the projection does on erasure, or makes
+// them available to other users if there is no such user.
+func releaseEmails(tx *events.Tx, p *projection.UsersProjection, userID string) error {
+	rows, err := tx.Query("SELECT email FROM email_reservations WHERE user_id = ?", userID)
+	if err != nil {
+		return fmt.Errorf("Failed to find emails of user %s: %s", userID, err)
+	}
+
+	emails := []string{}
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err != nil {
+			rows.Close()
+			return fmt.Errorf("Failed to scan email of user %s: %s", userID, err)
+		}
+
+		emails = append(emails, email)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("Failed to find emails of user %s: %s", userID, err)
+	}
+
+	for _, email := range emails {
+		if next := users.NextOwnerOfEmail(p, email, userID); next != nil {
+			_, err = tx.Exec(
+				"UPDATE email_reservations SET user_id = ?, reserved_at = ? WHERE email = ?",
+				next.GetId(), time.Now().UnixMilli(), email,
+			)
+		} else {
+			_, err = tx.Exec("DELETE FROM email_reservations WHERE email = ?", email)
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to release email %s of user %s: %s", email, userID, err)
+		}
+	}
+
+	return nil
+}
+
+// ReserveExistingEmails reserves emails of users created before reservations
+// exist. When more than one user has the same email, the first created one
+// gets the email. Does nothing once any email is reserved. Returns the number
+// of reserved emails.
+func ReserveExistingEmails(db *sql.DB) (int, error) {
+	var reserved bool
+	if err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM email_reservations)").Scan(&reserved); err != nil {
+		return 0, fmt.Errorf("Failed to find email reservations: %s", err)
+	}
+
+	if reserved {
+		return 0, nil
+	}
+
+	p, _, err := users.GetProjection(db)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(p.IndexByEmail) == 0 {
+		return 0, nil
+	}
+
+	tx, err := db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to begin transaction for reserving emails: %s", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UnixMilli()
+	for email, i := range p.IndexByEmail {
+		_, err := tx.Exec(
+			"INSERT INTO email_reservations (email, user_id, reserved_at) VALUES (?, ?, ?)",
+			email, p.Users[i].GetId(), now,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("Failed to reserve email %s: %s", email, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("Failed to commit email reservations: %s", err)
+	}
+
+	return len(p.IndexByEmail), nil
+}
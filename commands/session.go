@@ -0,0 +1,243 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package commands
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/proto"
+
+	"pocka.jp/x/event_sourcing_user_management_poc/events"
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/event"
+	"pocka.jp/x/event_sourcing_user_management_poc/projections/sessions"
+)
+
+var (
+	ErrSessionNotFound = errors.New("Session not found")
+	ErrSessionEnded    = errors.New("Session is ended")
+)
+
+// Session is an aggregate of a login session.
+type Session struct {
+	ID string
+
+	// Number of events in the session's stream.
+	Version int
+
+	Started bool
+	UserID  string
+
+	// Unix milliseconds.
+	LastSeenAt    int64
+	ExpiresAt     int64
+	IdleTimeoutMs int64
+
+	Ended bool
+}
+
+// LoadSession rehydrates the session from events of the session's stream.
+func LoadSession(q events.Queryer, id string) (*Session, error) {
+	s := &Session{ID: id}
+
+	for ev, err := range events.Iterate(q, events.IterateOptions{Stream: events.SessionStream(id)}) {
+		if err != nil {
+			return nil, err
+		}
+
+		s.apply(ev.Message)
+		s.Version = ev.StreamVersion
+	}
+
+	return s, nil
+}
+
+func (s *Session) apply(ev proto.Message) {
+	switch v := ev.(type) {
+	case *event.SessionStarted:
+		s.Started = true
+		s.UserID = v.GetUserId()
+		s.LastSeenAt = v.GetStartedAt()
+		s.ExpiresAt = v.GetExpiresAt()
+		s.IdleTimeoutMs = v.GetIdleTimeoutMs()
+	case *event.SessionRefreshed:
+		s.LastSeenAt = max(s.LastSeenAt, v.GetSeenAt())
+	case *event.SessionEnded:
+		s.Ended = true
+	}
+}
+
+// active reports whether the session is started, not ended, and not expired at now.
+func (s *Session) active(now time.Time) bool {
+	return s.Started && !s.Ended && now.UnixMilli() < min(s.ExpiresAt, s.LastSeenAt+s.IdleTimeoutMs)
+}
+
+// StartSession starts a login session of an existing user from the client,
+// which ends after lifetime or when it is not used for idleTimeout. Returns
+// the session ID and the token to give to the client. Only the hash of the
+// token is stored.
+func StartSession(
+	db *sql.DB,
+	metadata events.Metadata,
+	userID string,
+	ipAddress string,
+	userAgent string,
+	lifetime time.Duration,
+	idleTimeout time.Duration,
+	now time.Time,
+) (string, string, error) {
+	if lifetime <= 0 || idleTimeout <= 0 {
+		return "", "", fmt.Errorf("%w: lifetime and idle timeout must be positive", ErrInvalidCommand)
+	}
+
+	u, err := LoadUser(db, userID)
+	if err != nil {
+		return "", "", err
+	}
+
+	if !u.Created {
+		return "", "", fmt.Errorf("%w: %s", ErrUserNotFound, userID)
+	}
+
+	if u.Erased {
+		return "", "", fmt.Errorf("%w: %s", ErrUserErased, userID)
+	}
+
+	id := uuid.New().String()
+	token := rand.Text()
+
+	err = events.InsertWithExpectedVersion(db, events.SessionStream(id), events.NoStream, metadata, []proto.Message{
+		&event.SessionStarted{
+			SessionId:     proto.String(id),
+			UserId:        proto.String(userID),
+			TokenHash:     sessions.HashToken(token),
+			StartedAt:     proto.Int64(now.UnixMilli()),
+			ExpiresAt:     proto.Int64(now.Add(lifetime).UnixMilli()),
+			IdleTimeoutMs: proto.Int64(idleTimeout.Milliseconds()),
+			IpAddress:     proto.String(ipAddress),
+			UserAgent:     proto.String(userAgent),
+		},
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	return id, token, nil
+}
+
+// RefreshSession records the session is used at now, extending its idle timeout.
+// Returns an error wrapping ErrSessionEnded if the session is ended or expired.
+func RefreshSession(db *sql.DB, metadata events.Metadata, id string, now time.Time) error {
+	s, err := LoadSession(db, id)
+	if err != nil {
+		return err
+	}
+
+	if !s.Started {
+		return fmt.Errorf("%w: %s", ErrSessionNotFound, id)
+	}
+
+	if !s.active(now) {
+		return fmt.Errorf("%w: %s", ErrSessionEnded, id)
+	}
+
+	return events.InsertWithExpectedVersion(db, events.SessionStream(id), s.Version, metadata, []proto.Message{
+		&event.SessionRefreshed{
+			SessionId: proto.String(id),
+			SeenAt:    proto.Int64(now.UnixMilli()),
+		},
+	})
+}
+
+// EndSession ends the session for the reason. Returns an error wrapping
+// ErrSessionEnded if the session is already ended.
+func EndSession(
+	db *sql.DB,
+	metadata events.Metadata,
+	id string,
+	reason event.SessionEnded_Reason,
+	now time.Time,
+) error {
+	s, err := LoadSession(db, id)
+	if err != nil {
+		return err
+	}
+
+	if !s.Started {
+		return fmt.Errorf("%w: %s", ErrSessionNotFound, id)
+	}
+
+	if s.Ended {
+		return fmt.Errorf("%w: %s", ErrSessionEnded, id)
+	}
+
+	return events.InsertWithExpectedVersion(db, events.SessionStream(id), s.Version, metadata, []proto.Message{
+		&event.SessionEnded{
+			SessionId: proto.String(id),
+			Reason:    reason.Enum(),
+			EndedAt:   proto.Int64(now.UnixMilli()),
+		},
+	})
+}
+
+// RevokeSessions ends the sessions with REASON_REVOKED in a single
+// transaction. Sessions already ended are skipped. Returns the number of
+// revoked sessions.
+func RevokeSessions(db *sql.DB, metadata events.Metadata, ids []string, now time.Time) (int, error) {
+	revoked := 0
+	err := events.Transact(db, func(tx *events.Tx) error {
+		revoked = 0
+		for _, id := range ids {
+			ended, err := revokeSession(tx, metadata, id, now)
+			if err != nil {
+				return err
+			}
+
+			if ended {
+				revoked++
+			}
+		}
+
+		return nil
+	})
+
+	return revoked, err
+}
+
+// revokeSession ends the session with REASON_REVOKED in the transaction.
+// Returns false if the session is already ended.
+func revokeSession(tx *events.Tx, metadata events.Metadata, id string, now time.Time) (bool, error) {
+	s, err := LoadSession(tx, id)
+	if err != nil {
+		return false, err
+	}
+
+	if !s.Started {
+		return false, fmt.Errorf("%w: %s", ErrSessionNotFound, id)
+	}
+
+	if s.Ended {
+		return false, nil
+	}
+
+	err = tx.InsertWithExpectedVersion(events.SessionStream(id), s.Version, metadata, []proto.Message{
+		&event.SessionEnded{
+			SessionId: proto.String(id),
+			Reason:    event.SessionEnded_REASON_REVOKED.Enum(),
+			EndedAt:   proto.Int64(now.UnixMilli()),
+		},
+	})
+
+	return err == nil, err
+}
@@ -0,0 +1,153 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package commands
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"pocka.jp/x/event_sourcing_user_management_poc/events"
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/event"
+	"pocka.jp/x/event_sourcing_user_management_poc/projections/sessions"
+)
+
+func TestSession(t *testing.T) {
+	db := openDB(t)
+
+	if err := Handle(db, events.Metadata{}, CreateUser{ID: "foo", DisplayName: "Foo", Email: "foo@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.UnixMilli(1_000_000)
+
+	id, token, err := StartSession(db, events.Metadata{}, "foo", "192.0.2.1", "Test/1.0", time.Hour, time.Minute, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, _, err := sessions.GetProjection(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := sessions.FindByToken(p, token); s.GetId() != id || s.GetUserId() != "foo" {
+		t.Errorf("Expected session %s of user foo, got %v", id, s)
+	}
+
+	if err := RefreshSession(db, events.Metadata{}, id, now.Add(50*time.Second)); err != nil {
+		t.Errorf("Expected refresh within idle timeout to succeed, got %v", err)
+	}
+
+	// 70 seconds since the start, but 20 seconds since the refresh.
+	s, err := LoadSession(db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !s.active(now.Add(70 * time.Second)) {
+		t.Errorf("Expected session to be active after refresh: %+v", s)
+	}
+
+	err = RefreshSession(db, events.Metadata{}, id, now.Add(3*time.Minute))
+	if !errors.Is(err, ErrSessionEnded) {
+		t.Errorf("Expected ErrSessionEnded for idle session, got %v", err)
+	}
+
+	if err := EndSession(db, events.Metadata{}, id, event.SessionEnded_REASON_EXPIRED, now.Add(3*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	err = EndSession(db, events.Metadata{}, id, event.SessionEnded_REASON_LOGOUT, now.Add(3*time.Minute))
+	if !errors.Is(err, ErrSessionEnded) {
+		t.Errorf("Expected ErrSessionEnded for ended session, got %v", err)
+	}
+
+	err = EndSession(db, events.Metadata{}, "bar", event.SessionEnded_REASON_LOGOUT, now)
+	if !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected ErrSessionNotFound, got %v", err)
+	}
+}
+
+func TestStartSessionOfUnknownUser(t *testing.T) {
+	db := openDB(t)
+
+	_, _, err := StartSession(db, events.Metadata{}, "foo", "192.0.2.1", "Test/1.0", time.Hour, time.Minute, time.Now())
+	if !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+
+	if err := Handle(db, events.Metadata{}, CreateUser{ID: "foo", DisplayName: "Foo", Email: "foo@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := EraseUser(db, events.Metadata{}, "foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = StartSession(db, events.Metadata{}, "foo", "192.0.2.1", "Test/1.0", time.Hour, time.Minute, time.Now())
+	if !errors.Is(err, ErrUserErased) {
+		t.Errorf("Expected ErrUserErased, got %v", err)
+	}
+}
+
+func TestRevokeSessions(t *testing.T) {
+	db := openDB(t)
+
+	if err := Handle(db, events.Metadata{}, CreateUser{ID: "foo", DisplayName: "Foo", Email: "foo@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+
+	ids := []string{}
+	for range 3 {
+		id, _, err := StartSession(db, events.Metadata{}, "foo", "192.0.2.1", "Test/1.0", time.Hour, time.Minute, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids = append(ids, id)
+	}
+
+	if err := EndSession(db, events.Metadata{}, ids[0], event.SessionEnded_REASON_LOGOUT, now); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := RevokeSessions(db, events.Metadata{}, ids[:2], now); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Errorf("Expected 1 revoked session, got %d", n)
+	}
+
+	if _, err := RevokeSessions(db, events.Metadata{}, []string{ids[2], "bar"}, now); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected ErrSessionNotFound, got %v", err)
+	}
+
+	// Nothing is revoked if any session is not found.
+	if s, err := LoadSession(db, ids[2]); err != nil {
+		t.Fatal(err)
+	} else if s.Ended {
+		t.Errorf("Expected session to be kept, got %+v", s)
+	}
+
+	if err := EraseUser(db, events.Metadata{}, "foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	p, _, err := sessions.GetProjection(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if list := sessions.ListByUser(p, "foo"); len(list) != 0 {
+		t.Errorf("Expected sessions of erased user to be revoked, got %v", list)
+	}
+}
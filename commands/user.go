@@ -0,0 +1,105 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package commands
+
+import (
+	"database/sql"
+
+	"google.golang.org/protobuf/proto"
+
+	"pocka.jp/x/event_sourcing_user_management_poc/events"
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/event"
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/model"
+)
+
+// User is an aggregate of a user, holding only what commands need to
+// validate invariants.
+type User struct {
+	ID string
+
+	// Number of events in the user's stream.
+	Version int
+
+	Created       bool
+	Email         string
+	Role          model.Role
+	PasswordLogin bool
+	Erased        bool
+}
+
+// Events the user aggregate is built from.
+var userEventTypes = []string{
+	"event.UserCreated",
+	"event.PasswordLoginConfigured",
+	"event.RoleAssigned",
+	"event.UserErased",
+}
+
+// LoadUser rehydrates the user from events of the user's stream. Events
+// inserted before stream support are read as well, as they have no stream.
+// Only ones mentioning the user's ID are loaded from those.
+// Returns a user not yet created if there is no event for the user.
+func LoadUser(db *sql.DB, id string) (*User, error) {
+	u := &User{ID: id}
+
+	legacySeq, err := events.LastUnstreamedSeq(db)
+	if err != nil {
+		return nil, err
+	}
+
+	if legacySeq > 0 {
+		opts := events.IterateOptions{ToSeq: legacySeq, Types: userEventTypes, PayloadContains: id}
+		for ev, err := range events.Iterate(db, opts) {
+			if err != nil {
+				return nil, err
+			}
+
+			if ev.StreamID == "" {
+				u.apply(ev.Message)
+			}
+		}
+	}
+
+	for ev, err := range events.Iterate(db, events.IterateOptions{Stream: events.UserStream(id)}) {
+		if err != nil {
+			return nil, err
+		}
+
+		u.apply(ev.Message)
+		u.Version = ev.StreamVersion
+	}
+
+	return u, nil
+}
+
+// apply updates the user by the event. Events of other users are ignored.
+func (u *User) apply(ev proto.Message) {
+	switch v := ev.(type) {
+	case *event.UserCreated:
+		// The first one wins, same as the users projection.
+		if v.GetId() == u.ID && !u.Created {
+			u.Created = true
+			u.Email = v.GetEmail()
+		}
+	case *event.PasswordLoginConfigured:
+		if v.GetUserId() == u.ID && u.Created {
+			u.PasswordLogin = true
+		}
+	case *event.RoleAssigned:
+		if v.GetUserId() == u.ID && u.Created {
+			u.Role = v.GetRole()
+		}
+	case *event.UserErased:
+		if v.GetUserId() == u.ID && u.Created {
+			u.Email = ""
+			u.Erased = true
+		}
+	}
+}
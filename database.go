@@ -0,0 +1,110 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/charmbracelet/log"
+
+	"pocka.jp/x/event_sourcing_user_management_poc/commands"
+	"pocka.jp/x/event_sourcing_user_management_poc/migrations"
+)
+
+const inMemoryDatabase = ":memory:"
+
+// openDatabase opens SQLite3 database at the path and applies pending schema
+// migrations. When the path is ":memory:", the database lives only while the
+// process is running.
+func openDatabase(path string, logger *log.Logger) (*sql.DB, error) {
+	dsn := inMemoryDatabase
+	if path != inMemoryDatabase {
+		// Transactions take the write lock at BEGIN, where busy_timeout makes
+		// concurrent writers wait for it. A deferred transaction upgrading to a
+		// writer after its first read fails with SQLITE_BUSY right away instead,
+		// as the snapshot it read may be stale in WAL mode.
+		// secure_delete overwrites deleted rows, so erased data keys and
+		// snapshots do not remain in free space of the file.
+		dsn = fmt.Sprintf(
+			"file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=secure_delete(on)&_txlock=immediate",
+			path,
+		)
+	}
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open database at %s: %s", path, err)
+	}
+
+	if path == inMemoryDatabase {
+		// Every connection to ":memory:" creates a new, empty database.
+		// Restrict the pool to one connection so every query sees the same tables.
+		db.SetMaxOpenConns(1)
+	}
+
+	applied, err := migrations.Apply(db)
+	for _, m := range applied {
+		logger.Debugf("Applied migration %04d_%s", m.Version, m.Name)
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	// Events are never hashed automatically, as it would hide tampered events
+	// whose hashes are removed.
+	var unchained int
+	err = db.QueryRow(
+		"SELECT count(*) FROM user_events WHERE hash IS NULL AND NOT EXISTS (SELECT 1 FROM event_chain)",
+	).Scan(&unchained)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Failed to count events not in the hash chain: %s", err)
+	}
+	if unchained > 0 {
+		logger.Warnf(
+			"%d events are inserted before the hash chain exists. Run hash-legacy-events command before inserting events to add them to the chain",
+			unchained,
+		)
+	}
+
+	version, err := migrations.CurrentVersion(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	var eventCount int
+	if err := db.QueryRow("SELECT count(*) FROM user_events").Scan(&eventCount); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Failed to count existing user_events: %s", err)
+	}
+
+	logger.Infof("Opened database at %s (schema version=%d, events=%d)", path, version, eventCount)
+
+	return db, nil
+}
+
+// reserveExistingEmails reserves emails of users created before email
+// reservations exist. This replays the users projection, so it runs only when
+// the server starts, not for maintenance commands inspecting the database.
+func reserveExistingEmails(db *sql.DB, logger *log.Logger) error {
+	reserved, err := commands.ReserveExistingEmails(db)
+	if err != nil {
+		return err
+	}
+
+	if reserved > 0 {
+		logger.Infof("Reserved %d emails of users created before email reservations exist", reserved)
+	}
+
+	return nil
+}
@@ -0,0 +1,173 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/charmbracelet/log"
+	"google.golang.org/protobuf/proto"
+
+	"pocka.jp/x/event_sourcing_user_management_poc/commands"
+	"pocka.jp/x/event_sourcing_user_management_poc/events"
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/event"
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/model"
+	"pocka.jp/x/event_sourcing_user_management_poc/projections/users"
+)
+
+func openFileDB(t *testing.T) (*sql.DB, string) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	db, err := openDatabase(path, log.New(io.Discard))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db, path
+}
+
+func TestConcurrentWriters(t *testing.T) {
+	db, _ := openFileDB(t)
+
+	const writers = 40
+
+	var wg sync.WaitGroup
+	errs := make([]error, writers)
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			errs[i] = events.InsertWithExpectedVersion(
+				db, events.UserStream("foo"), events.AnyVersion, events.Metadata{},
+				[]proto.Message{&event.RoleAssigned{UserId: proto.String(fmt.Sprint(i))}},
+			)
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("Writer %d failed: %s", i, err)
+		}
+	}
+
+	if seq, err := events.LatestSeq(db); err != nil {
+		t.Fatal(err)
+	} else if seq != writers {
+		t.Errorf("Expected %d events, got %d", writers, seq)
+	}
+}
+
+func TestConcurrencyConflict(t *testing.T) {
+	db, _ := openFileDB(t)
+
+	if err := commands.Handle(db, events.Metadata{}, commands.CreateUser{
+		ID: "foo", DisplayName: "Foo", Email: "foo@example.com",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both writers expect the version before the other one's write.
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			errs[i] = events.InsertWithExpectedVersion(
+				db, events.UserStream("foo"), 1, events.Metadata{},
+				[]proto.Message{&event.RoleAssigned{UserId: proto.String("foo"), Role: model.Role_ROLE_EDITOR.Enum()}},
+			)
+		}()
+	}
+	wg.Wait()
+
+	conflicts := 0
+	for _, err := range errs {
+		if errors.Is(err, events.ErrConcurrencyConflict) {
+			conflicts++
+		} else if err != nil {
+			t.Errorf("Expected nil or ErrConcurrencyConflict, got %s", err)
+		}
+	}
+
+	if conflicts != 1 {
+		t.Errorf("Expected 1 conflict, got %v", errs)
+	}
+
+	// Handle retries on conflicts.
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			errs[i] = commands.Handle(db, events.Metadata{}, commands.AssignRole{ID: "foo", Role: model.Role_ROLE_VIEWER})
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Errorf("Expected commands to succeed after retries, got %s", err)
+		}
+	}
+}
+
+func TestEraseUserLeavesNoData(t *testing.T) {
+	db, path := openFileDB(t)
+
+	if err := commands.Handle(db, events.Metadata{}, commands.CreateUser{
+		ID: "foo", DisplayName: "Personal Name", Email: "foo@example.com",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := users.Projection.SaveSnapshot(db); err != nil {
+		t.Fatal(err)
+	}
+
+	var key []byte
+	if err := db.QueryRow("SELECT data_key FROM user_data_keys WHERE user_id = 'foo'").Scan(&key); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := commands.EraseUser(db, events.Metadata{}, "foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range []string{path, path + "-wal"} {
+		data, err := os.ReadFile(file)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		if bytes.Contains(data, key) {
+			t.Errorf("Expected the data key to be gone from %s", file)
+		}
+
+		// Users snapshot has the display name in plaintext.
+		if bytes.Contains(data, []byte("Personal Name")) {
+			t.Errorf("Expected the display name to be gone from %s", file)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package events
+
+import (
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/event"
+)
+
+func init() {
+	// Every message under proto/event/ is an event.
+	RegisterPackage("event")
+
+	// Events used to be stored by Go type name, which does not contain package name.
+	Register(&event.InitialAdminCreationPasswordCreated{}, "InitialAdminCreationPasswordCreated")
+	Register(&event.UserCreated{}, "UserCreated")
+	Register(&event.PasswordLoginConfigured{}, "PasswordLoginConfigured")
+	Register(&event.RoleAssigned{}, "RoleAssigned")
+}
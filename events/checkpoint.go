@@ -0,0 +1,92 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package events
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// checkpointMessage returns bytes to sign for a checkpoint.
+func checkpointMessage(seq int, hash []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("user_events checkpoint\x00")
+	binary.Write(&b, binary.BigEndian, int64(seq))
+	b.Write(hash)
+
+	return b.Bytes()
+}
+
+// SignCheckpoint signs the hash of the last event with the key and saves it.
+// Does nothing if the last event is already signed or has no hash. Returns the
+// seq of the signed event, or 0 if nothing is signed.
+func SignCheckpoint(db *sql.DB, key ed25519.PrivateKey, now time.Time) (int, error) {
+	var seq int
+	var hash []byte
+	err := db.QueryRow(
+		"SELECT seq, hash FROM user_events"+
+			" WHERE seq > (SELECT coalesce(max(event_seq), 0) FROM event_checkpoints)"+
+			" ORDER BY seq DESC LIMIT 1",
+	).Scan(&seq, &hash)
+	if err == sql.ErrNoRows || (err == nil && hash == nil) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("Failed to get the last event to sign: %s", err)
+	}
+
+	_, err = db.Exec(
+		"INSERT INTO event_checkpoints (event_seq, hash, signature, created_at) VALUES (?, ?, ?, ?)",
+		seq, hash, ed25519.Sign(key, checkpointMessage(seq, hash)), now.UnixMilli(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to save checkpoint at seq=%d: %s", seq, err)
+	}
+
+	return seq, nil
+}
+
+// VerifyCheckpoints checks signatures of every checkpoint, and whether signed
+// events are kept as signed. Returns events failed to verify.
+func VerifyCheckpoints(db *sql.DB, key ed25519.PublicKey) ([]*TamperedError, error) {
+	rows, err := db.Query(
+		"SELECT c.event_seq, c.hash, c.signature, e.hash FROM event_checkpoints AS c" +
+			" LEFT JOIN user_events AS e ON e.seq = c.event_seq" +
+			" ORDER BY c.event_seq ASC",
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list checkpoints: %s", err)
+	}
+	defer rows.Close()
+
+	tampered := []*TamperedError{}
+	for rows.Next() {
+		var seq int
+		var signedHash, signature, eventHash []byte
+		if err := rows.Scan(&seq, &signedHash, &signature, &eventHash); err != nil {
+			return nil, fmt.Errorf("Failed to scan checkpoint: %s", err)
+		}
+
+		if !ed25519.Verify(key, checkpointMessage(seq, signedHash), signature) {
+			tampered = append(tampered, &TamperedError{Seq: seq, Reason: "checkpoint signature is invalid"})
+		} else if !bytes.Equal(signedHash, eventHash) {
+			tampered = append(tampered, &TamperedError{Seq: seq, Reason: "event differs from the signed checkpoint"})
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Failed to list checkpoints: %s", err)
+	}
+
+	return tampered, nil
+}
@@ -0,0 +1,63 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package events
+
+import (
+	"database/sql"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Metadata is an envelope of events, stored alongside each event.
+// Every field is optional. Events inserted before metadata support have
+// zero values.
+type Metadata struct {
+	// When the event happened. Insert uses the current time if this is zero.
+	OccurredAt time.Time
+
+	// ID of the user who triggered the event.
+	ActorID string
+
+	// ID shared among events caused by a same request.
+	CorrelationID string
+
+	// ID of the message directly caused the event.
+	CausationID string
+}
+
+// Event is a stored event with its position in the log.
+type Event struct {
+	Seq      int
+	Name     string
+	Message  proto.Message
+	Metadata Metadata
+
+	// StreamID is empty and StreamVersion is 0 for events inserted before
+	// stream support.
+	StreamID      string
+	StreamVersion int
+}
+
+// Columns is a list of user_events columns, in the order ScanEvent expects.
+// The last two are the hash of the previous event, thus the table must not be
+// aliased, and the start of the hash chain.
+const Columns = "seq, event_name, payload, occurred_at, actor_id, correlation_id, causation_id," +
+	" stream_id, stream_version, schema_version, hash," +
+	" (SELECT prev.hash FROM user_events AS prev WHERE prev.seq < user_events.seq ORDER BY prev.seq DESC LIMIT 1)," +
+	" (SELECT start_seq FROM event_chain)"
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func nullTime(t time.Time) sql.NullInt64 {
+	return sql.NullInt64{Int64: t.UnixMilli(), Valid: !t.IsZero()}
+}
@@ -0,0 +1,296 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package events
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	_ "modernc.org/sqlite"
+
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/event"
+	"pocka.jp/x/event_sourcing_user_management_poc/migrations"
+)
+
+func openDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := migrations.Apply(db); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestMetadataRoundTrip(t *testing.T) {
+	db := openDB(t)
+
+	occurredAt := time.UnixMilli(1700000000000)
+
+	err := Insert(db, "foo", Metadata{
+		OccurredAt:    occurredAt,
+		ActorID:       "actor",
+		CorrelationID: "correlation",
+		CausationID:   "causation",
+	}, []proto.Message{
+		&event.UserCreated{Id: proto.String("foo")},
+		&event.RoleAssigned{UserId: proto.String("foo")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := List(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(list))
+	}
+
+	for _, ev := range list {
+		if !ev.Metadata.OccurredAt.Equal(occurredAt) {
+			t.Errorf("Expected OccurredAt %s, got %s", occurredAt, ev.Metadata.OccurredAt)
+		}
+
+		if ev.Metadata.ActorID != "actor" {
+			t.Errorf("Expected ActorID \"actor\", got \"%s\"", ev.Metadata.ActorID)
+		}
+
+		if ev.Metadata.CorrelationID != "correlation" {
+			t.Errorf("Expected CorrelationID \"correlation\", got \"%s\"", ev.Metadata.CorrelationID)
+		}
+
+		if ev.Metadata.CausationID != "causation" {
+			t.Errorf("Expected CausationID \"causation\", got \"%s\"", ev.Metadata.CausationID)
+		}
+	}
+
+	if _, ok := list[0].Message.(*event.UserCreated); !ok {
+		t.Errorf("Expected UserCreated, got %T", list[0].Message)
+	}
+}
+
+func TestDefaultOccurredAt(t *testing.T) {
+	db := openDB(t)
+
+	before := time.Now().Add(-time.Second)
+
+	if err := Insert(db, "foo", Metadata{}, []proto.Message{
+		&event.UserCreated{Id: proto.String("foo")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := List(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if list[0].Metadata.OccurredAt.Before(before) {
+		t.Errorf("Expected OccurredAt to be set to now, got %s", list[0].Metadata.OccurredAt)
+	}
+
+	if list[0].Metadata.ActorID != "" {
+		t.Errorf("Expected empty ActorID, got \"%s\"", list[0].Metadata.ActorID)
+	}
+}
+
+func TestStreamVersion(t *testing.T) {
+	db := openDB(t)
+
+	if err := Insert(db, "foo", Metadata{}, []proto.Message{
+		&event.UserCreated{Id: proto.String("foo")},
+		&event.RoleAssigned{UserId: proto.String("foo")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Insert(db, "bar", Metadata{}, []proto.Message{
+		&event.UserCreated{Id: proto.String("bar")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := StreamVersion(db, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if version != 2 {
+		t.Errorf("Expected version 2, got %d", version)
+	}
+
+	list, err := List(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if list[2].StreamID != "bar" || list[2].StreamVersion != 1 {
+		t.Errorf("Expected bar@1, got %s@%d", list[2].StreamID, list[2].StreamVersion)
+	}
+}
+
+func TestExpectedVersion(t *testing.T) {
+	db := openDB(t)
+
+	if err := InsertWithExpectedVersion(db, "foo", NoStream, Metadata{}, []proto.Message{
+		&event.UserCreated{Id: proto.String("foo")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := InsertWithExpectedVersion(db, "foo", 1, Metadata{}, []proto.Message{
+		&event.RoleAssigned{UserId: proto.String("foo")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := InsertWithExpectedVersion(db, "foo", 1, Metadata{}, []proto.Message{
+		&event.RoleAssigned{UserId: proto.String("foo")},
+	})
+	if !errors.Is(err, ErrConcurrencyConflict) {
+		t.Fatalf("Expected ErrConcurrencyConflict, got %v", err)
+	}
+
+	var conflict *ConcurrencyConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("Expected ConcurrencyConflictError, got %T", err)
+	}
+
+	if conflict.Expected != 1 || conflict.Actual != 2 {
+		t.Errorf("Expected 1 and 2, got %d and %d", conflict.Expected, conflict.Actual)
+	}
+
+	err = InsertWithExpectedVersion(db, "foo", NoStream, Metadata{}, []proto.Message{
+		&event.UserCreated{Id: proto.String("foo")},
+	})
+	if !errors.Is(err, ErrConcurrencyConflict) {
+		t.Errorf("Expected ErrConcurrencyConflict for existing stream, got %v", err)
+	}
+}
+
+func TestIterate(t *testing.T) {
+	db := openDB(t)
+
+	// Legacy row, stored with a name without package before the hash chain exists.
+	payload, err := proto.Marshal(&event.UserCreated{Id: proto.String("legacy")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec(
+		"INSERT INTO user_events (event_name, payload) VALUES ('UserCreated', ?)", payload,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := HashLegacyEvents(db); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range 5 {
+		if err := Insert(db, "foo", Metadata{}, []proto.Message{
+			&event.UserCreated{Id: proto.String(fmt.Sprint(i))},
+			&event.RoleAssigned{UserId: proto.String(fmt.Sprint(i))},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seqs := []int{}
+	for ev, err := range Iterate(db, IterateOptions{FromSeq: 2, ToSeq: 9, BatchSize: 3}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		seqs = append(seqs, ev.Seq)
+	}
+
+	if !slices.Equal(seqs, []int{2, 3, 4, 5, 6, 7, 8, 9}) {
+		t.Errorf("Expected seq 2 to 9, got %v", seqs)
+	}
+
+	ids := []string{}
+	for ev, err := range Iterate(db, IterateOptions{Types: []string{"event.UserCreated"}, BatchSize: 2}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids = append(ids, ev.Message.(*event.UserCreated).GetId())
+	}
+
+	if !slices.Equal(ids, []string{"legacy", "0", "1", "2", "3", "4"}) {
+		t.Errorf("Expected every UserCreated, got %v", ids)
+	}
+
+	seqs = []int{}
+	for ev, err := range Iterate(db, IterateOptions{PayloadContains: "3"}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		seqs = append(seqs, ev.Seq)
+	}
+
+	if !slices.Equal(seqs, []int{8, 9}) {
+		t.Errorf("Expected events of user 3, got %v", seqs)
+	}
+}
+
+func TestIterateUndecodable(t *testing.T) {
+	db := openDB(t)
+
+	if _, err := db.Exec(
+		"INSERT INTO user_events (event_name, payload) VALUES ('event.Unknown', x'00')",
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Insert(db, "foo", Metadata{}, []proto.Message{
+		&event.UserCreated{Id: proto.String("foo")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := 0
+	decoded := 0
+	for ev, err := range Iterate(db, IterateOptions{}) {
+		if err != nil {
+			if !errors.Is(err, ErrUnknownEvent) {
+				t.Errorf("Expected ErrUnknownEvent, got %v", err)
+			}
+
+			if ev.Seq != 1 {
+				t.Errorf("Expected seq of the failed event, got %d", ev.Seq)
+			}
+
+			errs++
+			continue
+		}
+
+		decoded++
+	}
+
+	if errs != 1 || decoded != 1 {
+		t.Errorf("Expected 1 error and 1 event, got %d and %d", errs, decoded)
+	}
+}
@@ -0,0 +1,246 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"time"
+)
+
+var ErrTampered = errors.New("Event is tampered")
+
+// TamperedError is returned when an event does not match its hash.
+// This error wraps ErrTampered.
+type TamperedError struct {
+	Seq    int
+	Reason string
+}
+
+func (e *TamperedError) Error() string {
+	return fmt.Sprintf("%s: seq=%d: %s", ErrTampered, e.Seq, e.Reason)
+}
+
+func (e *TamperedError) Unwrap() error {
+	return ErrTampered
+}
+
+// chainHash returns the hash of the event chained to the previous event's hash.
+func chainHash(prevHash []byte, row storedEvent) []byte {
+	h := sha256.New()
+
+	writeBytes(h, prevHash)
+	writeInt(h, row.Seq)
+	writeBytes(h, []byte(row.Name))
+	writeInt(h, row.schemaVersion)
+	writeBytes(h, row.payload)
+
+	occurredAt := 0
+	if !row.Metadata.OccurredAt.IsZero() {
+		occurredAt = int(row.Metadata.OccurredAt.UnixMilli())
+	}
+	writeInt(h, occurredAt)
+
+	writeBytes(h, []byte(row.Metadata.ActorID))
+	writeBytes(h, []byte(row.Metadata.CorrelationID))
+	writeBytes(h, []byte(row.Metadata.CausationID))
+	writeBytes(h, []byte(row.StreamID))
+	writeInt(h, row.StreamVersion)
+
+	return h.Sum(nil)
+}
+
+// writeBytes writes length-prefixed bytes, so adjacent fields cannot be
+// shifted into each other.
+func writeBytes(h hash.Hash, b []byte) {
+	binary.Write(h, binary.BigEndian, uint32(len(b)))
+	h.Write(b)
+}
+
+func writeInt(h hash.Hash, n int) {
+	binary.Write(h, binary.BigEndian, int64(n))
+}
+
+// verify checks the row against its hash and the previous event's hash.
+// Rows without hash are accepted only before the start of the hash chain, as
+// they are inserted before the hash chain exists.
+func (row storedEvent) verify() error {
+	if row.hash == nil {
+		if row.prevHash != nil || (row.chainStart.Valid && int64(row.Seq) >= row.chainStart.Int64) {
+			return &TamperedError{Seq: row.Seq, Reason: "hash is missing"}
+		}
+
+		return nil
+	}
+
+	if !bytes.Equal(row.hash, chainHash(row.prevHash, row)) {
+		return &TamperedError{Seq: row.Seq, Reason: "hash does not match"}
+	}
+
+	return nil
+}
+
+// hashInserted saves the hash of the event at seq. The hash is computed from
+// the stored row, so it matches the one computed on read. The hash chain
+// starts at the event if there is none yet.
+func hashInserted(tx *sql.Tx, seq int) error {
+	row, err := scanRow(tx.QueryRow("SELECT "+Columns+" FROM user_events WHERE seq = ?", seq))
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("UPDATE user_events SET hash = ? WHERE seq = ?", chainHash(row.prevHash, row), seq); err != nil {
+		return fmt.Errorf("Failed to save hash of event at seq=%d: %s", seq, err)
+	}
+
+	return startChain(tx, seq)
+}
+
+// startChain records the hash chain starts at seq, unless it already exists.
+func startChain(tx *sql.Tx, seq int) error {
+	_, err := tx.Exec(
+		"INSERT OR IGNORE INTO event_chain (id, start_seq, created_at) VALUES (1, ?, ?)",
+		seq, time.Now().UnixMilli(),
+	)
+	if err != nil {
+		return fmt.Errorf("Failed to record start of hash chain: %s", err)
+	}
+
+	return nil
+}
+
+// chainStart returns the seq of the first hashed event, or false if the hash
+// chain does not exist yet.
+func chainStart(q Queryer) (int, bool, error) {
+	rows, err := q.QueryContext(context.Background(), "SELECT start_seq FROM event_chain")
+	if err != nil {
+		return 0, false, fmt.Errorf("Failed to get start of hash chain: %s", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return 0, false, rows.Err()
+	}
+
+	var seq int
+	if err := rows.Scan(&seq); err != nil {
+		return 0, false, fmt.Errorf("Failed to scan start of hash chain: %s", err)
+	}
+
+	return seq, true, nil
+}
+
+// HashLegacyEvents starts the hash chain from the first event, hashing events
+// inserted before the hash chain exists. Fails if the hash chain already
+// exists or any event is hashed, as an event without hash after that is
+// a tampered one and must not be hashed again. Returns the number of hashed
+// events.
+func HashLegacyEvents(db *sql.DB) (int, error) {
+	ctx := context.Background()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to begin transaction for hashing events: %s", err)
+	}
+	defer tx.Rollback()
+
+	if start, ok, err := chainStart(tx); err != nil {
+		return 0, err
+	} else if ok {
+		return 0, fmt.Errorf("Hash chain already starts at seq=%d, refusing to hash events again", start)
+	}
+
+	var chained bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM user_events WHERE hash IS NOT NULL)").Scan(&chained)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to find hashed events: %s", err)
+	}
+
+	if chained {
+		return 0, fmt.Errorf("Found hashed events without start of hash chain, refusing to hash events again")
+	}
+
+	var prevHash []byte
+	next := 0
+	hashed := 0
+	for {
+		batch, err := loadBatch(
+			tx,
+			"SELECT "+Columns+" FROM user_events WHERE seq >= ? ORDER BY seq ASC LIMIT ?",
+			[]any{next, defaultBatchSize},
+		)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, row := range batch {
+			if hashed == 0 {
+				if err := startChain(tx, row.Seq); err != nil {
+					return 0, err
+				}
+			}
+
+			prevHash = chainHash(prevHash, row)
+			if _, err := tx.Exec("UPDATE user_events SET hash = ? WHERE seq = ?", prevHash, row.Seq); err != nil {
+				return 0, fmt.Errorf("Failed to save hash of event at seq=%d: %s", row.Seq, err)
+			}
+
+			next = row.Seq + 1
+			hashed++
+		}
+
+		if len(batch) < defaultBatchSize {
+			break
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("Failed to commit hashes of events: %s", err)
+	}
+
+	return hashed, nil
+}
+
+// VerifyChain checks every event against its hash, and returns tampered events.
+// Unlike Iterate, events are checked even if they cannot be decoded.
+func VerifyChain(db *sql.DB) ([]*TamperedError, error) {
+	tampered := []*TamperedError{}
+
+	next := 0
+	for {
+		batch, err := loadBatch(
+			db,
+			"SELECT "+Columns+" FROM user_events WHERE seq >= ? ORDER BY seq ASC LIMIT ?",
+			[]any{next, defaultBatchSize},
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, row := range batch {
+			var err *TamperedError
+			if errors.As(row.verify(), &err) {
+				tampered = append(tampered, err)
+			}
+
+			next = row.Seq + 1
+		}
+
+		if len(batch) < defaultBatchSize {
+			return tampered, nil
+		}
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package events
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/event"
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/model"
+)
+
+func TestHashChain(t *testing.T) {
+	db := openDB(t)
+
+	if err := Insert(db, "foo", Metadata{ActorID: "foo"}, []proto.Message{
+		&event.UserCreated{Id: proto.String("foo")},
+		&event.RoleAssigned{UserId: proto.String("foo"), Role: model.Role_ROLE_VIEWER.Enum()},
+		&event.UserCreated{Id: proto.String("bar")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tampered, err := VerifyChain(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tampered) != 0 {
+		t.Errorf("Expected no tampered events, got %v", tampered)
+	}
+
+	payload, err := proto.Marshal(&event.RoleAssigned{
+		UserId: proto.String("foo"),
+		Role:   model.Role_ROLE_ADMIN.Enum(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec("UPDATE user_events SET payload = ? WHERE seq = 2", payload); err != nil {
+		t.Fatal(err)
+	}
+
+	for ev, err := range Iterate(db, IterateOptions{}) {
+		if ev.Seq == 2 {
+			var tamperedErr *TamperedError
+			if !errors.As(err, &tamperedErr) || tamperedErr.Seq != 2 {
+				t.Errorf("Expected TamperedError at seq=2, got %v", err)
+			}
+		} else if err != nil {
+			t.Errorf("Expected no error at seq=%d, got %v", ev.Seq, err)
+		}
+	}
+
+	tampered, err = VerifyChain(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tampered) != 1 || tampered[0].Seq != 2 {
+		t.Errorf("Expected seq=2 to be tampered, got %v", tampered)
+	}
+}
+
+func TestCheckpoints(t *testing.T) {
+	db := openDB(t)
+
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"foo", "bar"} {
+		if err := Insert(db, UserStream(id), Metadata{}, []proto.Message{
+			&event.UserCreated{Id: proto.String(id)},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := SignCheckpoint(db, private, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seq, err := SignCheckpoint(db, private, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if seq != 0 {
+		t.Errorf("Expected nothing to sign, got seq=%d", seq)
+	}
+
+	tampered, err := VerifyCheckpoints(db, public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tampered) != 0 {
+		t.Errorf("Expected no tampered events, got %v", tampered)
+	}
+
+	// Hash of a signed event is changed.
+	if _, err := db.Exec("UPDATE user_events SET hash = NULL WHERE seq = 2"); err != nil {
+		t.Fatal(err)
+	}
+
+	other, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered, err = VerifyCheckpoints(db, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tampered) != 2 {
+		t.Errorf("Expected signatures to be invalid for another key, got %v", tampered)
+	}
+
+	tampered, err = VerifyCheckpoints(db, public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tampered) != 1 || tampered[0].Seq != 2 {
+		t.Errorf("Expected seq=2 to differ from the checkpoint, got %v", tampered)
+	}
+}
+
+func TestRemovedHashes(t *testing.T) {
+	db := openDB(t)
+
+	for i := range 3 {
+		if err := Insert(db, "foo", Metadata{}, []proto.Message{
+			&event.UserCreated{Id: proto.String(fmt.Sprint(i))},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Removing every hash does not make events look like legacy ones.
+	if _, err := db.Exec("UPDATE user_events SET hash = NULL"); err != nil {
+		t.Fatal(err)
+	}
+
+	tampered, err := VerifyChain(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tampered) != 3 {
+		t.Errorf("Expected every event to be tampered, got %v", tampered)
+	}
+
+	if _, err := HashLegacyEvents(db); err == nil {
+		t.Error("Expected hashing events to fail once the hash chain exists")
+	}
+
+	if _, err := List(db); !errors.Is(err, ErrTampered) {
+		t.Errorf("Expected ErrTampered, got %v", err)
+	}
+}
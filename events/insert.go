@@ -12,45 +12,186 @@ package events
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"reflect"
+	"time"
 
 	"google.golang.org/protobuf/proto"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
 )
 
-func Insert(db *sql.DB, events []proto.Message) error {
+// Insert appends events to the stream in a single transaction, regardless of
+// the current version of the stream. Every event shares the metadata.
+func Insert(db *sql.DB, stream string, metadata Metadata, events []proto.Message) error {
+	return InsertWithExpectedVersion(db, stream, AnyVersion, metadata, events)
+}
+
+// InsertWithExpectedVersion appends events to the stream in a single transaction,
+// only if the stream's current version equals to expectedVersion.
+// Pass NoStream to ensure the stream is new, or AnyVersion to skip the check.
+// Returns an error wrapping ErrConcurrencyConflict when the stream has moved on.
+func InsertWithExpectedVersion(
+	db *sql.DB,
+	stream string,
+	expectedVersion int,
+	metadata Metadata,
+	events []proto.Message,
+) error {
+	return Transact(db, func(tx *Tx) error {
+		return tx.InsertWithExpectedVersion(stream, expectedVersion, metadata, events)
+	})
+}
+
+// Tx is a transaction events are inserted in, along with other changes that
+// must be committed atomically with the events.
+type Tx struct {
+	*sql.Tx
+
+	lastSeq int
+}
+
+// Transact runs fn in a transaction, and commits it if fn returns nil.
+// Subscriptions are notified of events inserted by fn after the commit.
+func Transact(db *sql.DB, fn func(tx *Tx) error) error {
 	ctx := context.Background()
 
-	tx, err := db.BeginTx(ctx, nil)
+	sqlTx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("Failed to begin transaction for events insertion: %s", err)
 	}
-	defer tx.Rollback()
+	defer sqlTx.Rollback()
+
+	tx := &Tx{Tx: sqlTx}
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	if err = sqlTx.Commit(); err != nil {
+		return fmt.Errorf("Failed to commit transaction for events insertion: %s", err)
+	}
+
+	if tx.lastSeq > 0 {
+		hubFor(db).publish(tx.lastSeq)
+	}
+
+	return nil
+}
+
+// InsertWithExpectedVersion is the same as the package-level one, but inserts
+// in the transaction.
+func (tx *Tx) InsertWithExpectedVersion(
+	stream string,
+	expectedVersion int,
+	metadata Metadata,
+	events []proto.Message,
+) error {
+	lastSeq, err := insertTx(tx.Tx, stream, expectedVersion, metadata, events)
+	if err != nil {
+		return err
+	}
+
+	tx.lastSeq = max(tx.lastSeq, lastSeq)
+
+	return nil
+}
+
+// insertTx appends events to the stream in the transaction, and returns the
+// seq of the last inserted event. Personal data in events is encrypted.
+// Callers must publish the seq to the hub after committing the transaction.
+func insertTx(
+	tx *sql.Tx,
+	stream string,
+	expectedVersion int,
+	metadata Metadata,
+	events []proto.Message,
+) (int, error) {
+	if stream == "" {
+		return 0, fmt.Errorf("Stream ID is required for events insertion")
+	}
 
-	stmt, err := tx.Prepare("INSERT OR ABORT INTO user_events (payload, event_name) VALUES (?, ?)")
+	version, err := streamVersion(tx, stream)
 	if err != nil {
-		return fmt.Errorf("Failed to prepare INSERT statement for event insertion: %s", err)
+		return 0, err
+	}
+
+	if expectedVersion != AnyVersion && expectedVersion != version {
+		return 0, &ConcurrencyConflictError{
+			Stream:   stream,
+			Expected: expectedVersion,
+			Actual:   version,
+		}
 	}
 
+	if metadata.OccurredAt.IsZero() {
+		metadata.OccurredAt = time.Now()
+	}
+
+	stmt, err := tx.Prepare(`INSERT OR ABORT INTO user_events (
+		payload, event_name, occurred_at, actor_id, correlation_id, causation_id,
+		stream_id, stream_version, schema_version
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to prepare INSERT statement for event insertion: %s", err)
+	}
+
+	lastSeq := 0
 	for _, event := range events {
-		// event is proto.Message, which is interface type. So we have to
-		// get the type of the one it points to. Without "Indirect()", we get
-		// something like "*event.UserCreated"
-		eventName := reflect.Indirect(reflect.ValueOf(event)).Type().Name()
+		eventName, err := defaultRegistry.Name(event)
+		if err != nil {
+			return 0, err
+		}
 
-		data, err := proto.Marshal(event)
+		sealed, err := sealPersonalData(tx, event)
 		if err != nil {
-			return fmt.Errorf("Serializing of %s failed: %s", eventName, err)
+			return 0, err
 		}
 
-		if _, err := stmt.Exec(data, eventName); err != nil {
-			return fmt.Errorf("Failed to INSERT %s: %s", eventName, err)
+		data, err := proto.Marshal(sealed)
+		if err != nil {
+			return 0, fmt.Errorf("Serializing of %s failed: %s", eventName, err)
 		}
-	}
 
-	if err = tx.Commit(); err != nil {
-		return fmt.Errorf("Failed to commit transaction for events insertion: %s", err)
+		version++
+
+		res, err := stmt.Exec(
+			data,
+			eventName,
+			nullTime(metadata.OccurredAt),
+			nullString(metadata.ActorID),
+			nullString(metadata.CorrelationID),
+			nullString(metadata.CausationID),
+			stream,
+			version,
+			defaultRegistry.SchemaVersion(event.ProtoReflect().Descriptor().FullName()),
+		)
+		if err != nil {
+			var sqliteErr *sqlite.Error
+			if errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE {
+				// Another writer appended to the stream after we read the version.
+				// Only happens when the transaction did not take the write lock
+				// at BEGIN, as the version check above sees every commit otherwise.
+				return 0, &ConcurrencyConflictError{
+					Stream:   stream,
+					Expected: expectedVersion,
+					Actual:   version,
+				}
+			}
+
+			return 0, fmt.Errorf("Failed to INSERT %s: %s", eventName, err)
+		}
+
+		seq, err := res.LastInsertId()
+		if err != nil {
+			return 0, fmt.Errorf("Failed to get seq of inserted %s: %s", eventName, err)
+		}
+
+		lastSeq = int(seq)
+
+		if err := hashInserted(tx, lastSeq); err != nil {
+			return 0, err
+		}
 	}
 
-	return nil
+	return lastSeq, nil
 }
@@ -0,0 +1,197 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package events
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"iter"
+	"strings"
+)
+
+const defaultBatchSize = 500
+
+// Queryer is either *sql.DB or *sql.Tx.
+type Queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+type IterateOptions struct {
+	// Smallest seq to include. Zero means the beginning of the log.
+	FromSeq int
+
+	// Largest seq to include. Zero means the end of the log.
+	ToSeq int
+
+	// Fully-qualified event names (e.g. "event.UserCreated") to include.
+	// Events stored with aliases of the names are included too.
+	// Empty means every event.
+	Types []string
+
+	// Stream ID to include events of. Empty means every event, including
+	// events without stream.
+	Stream string
+
+	// Includes only events whose encoded payload contains the string, such as
+	// an ID. This is a cheap filter on bytes, so callers still have to check
+	// fields of the decoded events. Empty means every event.
+	PayloadContains string
+
+	// Number of events loaded per query. Defaults to 500.
+	BatchSize int
+
+	// Yields personal data as stored, without decryption.
+	sealed bool
+}
+
+// Iterate returns an iterator over events in user_events, in seq order.
+// Events are loaded in batches so the whole log is never in memory at once.
+//
+// When an event cannot be decoded, the iterator yields the event without
+// Message along with the error, and continues to the next event if the
+// consumer keeps going. Likewise, events not matching their hash are yielded
+// along with an error wrapping ErrTampered. Database errors stop the iteration.
+//
+// Personal data in events is decrypted. Personal data of erased users is
+// left empty.
+//
+// No database connection is held while the consumer processes events, so
+// it is safe to run queries inside the loop.
+func Iterate(q Queryer, opts IterateOptions) iter.Seq2[Event, error] {
+	return func(yield func(Event, error) bool) {
+		batchSize := opts.BatchSize
+		if batchSize <= 0 {
+			batchSize = defaultBatchSize
+		}
+
+		query, args, err := buildIterateQuery(opts)
+		if err != nil {
+			yield(Event{}, err)
+			return
+		}
+
+		next := max(opts.FromSeq, 0)
+		for {
+			batchArgs := append([]any{next}, args...)
+			batchArgs = append(batchArgs, batchSize)
+
+			batch, err := loadBatch(q, query, batchArgs)
+			if err != nil {
+				yield(Event{}, err)
+				return
+			}
+
+			decoded := make([]Event, len(batch))
+			errs := make([]error, len(batch))
+			for i, row := range batch {
+				decoded[i], errs[i] = row.decode()
+			}
+
+			keys := map[string][]byte{}
+			if !opts.sealed {
+				keys, err = loadDataKeys(q, decoded)
+				if err != nil {
+					yield(Event{}, err)
+					return
+				}
+			}
+
+			for i, event := range decoded {
+				err := errs[i]
+				if !opts.sealed {
+					if openErr := openPersonalData(keys, event); openErr != nil {
+						event.Message = nil
+						err = openErr
+					}
+				}
+
+				if !yield(event, err) {
+					return
+				}
+
+				next = event.Seq + 1
+			}
+
+			if len(batch) < batchSize {
+				return
+			}
+		}
+	}
+}
+
+// buildIterateQuery returns SQL and its arguments except the first (lower
+// bound of seq) and the last (LIMIT) ones.
+func buildIterateQuery(opts IterateOptions) (string, []any, error) {
+	var query strings.Builder
+	args := []any{}
+
+	query.WriteString("SELECT " + Columns + " FROM user_events WHERE seq >= ?")
+
+	if opts.ToSeq > 0 {
+		query.WriteString(" AND seq <= ?")
+		args = append(args, opts.ToSeq)
+	}
+
+	if opts.Stream != "" {
+		query.WriteString(" AND stream_id = ?")
+		args = append(args, opts.Stream)
+	}
+
+	if opts.PayloadContains != "" {
+		query.WriteString(" AND instr(payload, ?) > 0")
+		args = append(args, []byte(opts.PayloadContains))
+	}
+
+	if len(opts.Types) > 0 {
+		names := []string{}
+		for _, t := range opts.Types {
+			mt, err := defaultRegistry.Resolve(t)
+			if err != nil {
+				return "", nil, err
+			}
+
+			names = append(names, defaultRegistry.NamesOf(mt.Descriptor().FullName())...)
+		}
+
+		query.WriteString(" AND event_name IN (?" + strings.Repeat(", ?", len(names)-1) + ")")
+		for _, name := range names {
+			args = append(args, name)
+		}
+	}
+
+	query.WriteString(" ORDER BY seq ASC LIMIT ?")
+
+	return query.String(), args, nil
+}
+
+func loadBatch(q Queryer, query string, args []any) ([]storedEvent, error) {
+	rows, err := q.QueryContext(context.Background(), query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to SELECT user_events: %s", err)
+	}
+	defer rows.Close()
+
+	batch := []storedEvent{}
+	for rows.Next() {
+		row, err := scanRow(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		batch = append(batch, row)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Failed to read user_events: %s", err)
+	}
+
+	return batch, nil
+}
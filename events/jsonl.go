@@ -0,0 +1,212 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package events
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// exportedEvent is a line of exported JSON Lines.
+type exportedEvent struct {
+	Seq           int             `json:"seq"`
+	Name          string          `json:"name"`
+	OccurredAt    *time.Time      `json:"occurredAt,omitempty"`
+	ActorID       string          `json:"actorId,omitempty"`
+	CorrelationID string          `json:"correlationId,omitempty"`
+	CausationID   string          `json:"causationId,omitempty"`
+	StreamID      string          `json:"streamId,omitempty"`
+	StreamVersion int             `json:"streamVersion,omitempty"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+// Export writes every event as JSON Lines, one event per line, in seq order.
+// Payloads are written in Protobuf JSON format of the latest schema version,
+// with personal data decrypted. Fails if any event cannot be read. Returns
+// the number of exported events.
+func Export(db *sql.DB, w io.Writer) (int, error) {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+
+	exported := 0
+	for ev, err := range Iterate(db, IterateOptions{}) {
+		if err != nil {
+			return exported, err
+		}
+
+		payload, err := protojson.Marshal(ev.Message)
+		if err != nil {
+			return exported, fmt.Errorf("Failed to encode event at seq=%d into JSON: %s", ev.Seq, err)
+		}
+
+		line := exportedEvent{
+			Seq:           ev.Seq,
+			Name:          string(ev.Message.ProtoReflect().Descriptor().FullName()),
+			ActorID:       ev.Metadata.ActorID,
+			CorrelationID: ev.Metadata.CorrelationID,
+			CausationID:   ev.Metadata.CausationID,
+			StreamID:      ev.StreamID,
+			StreamVersion: ev.StreamVersion,
+			Payload:       payload,
+		}
+
+		if !ev.Metadata.OccurredAt.IsZero() {
+			occurredAt := ev.Metadata.OccurredAt.UTC()
+			line.OccurredAt = &occurredAt
+		}
+
+		if err := encoder.Encode(line); err != nil {
+			return exported, fmt.Errorf("Failed to write event at seq=%d: %s", ev.Seq, err)
+		}
+
+		exported++
+	}
+
+	return exported, nil
+}
+
+// Import inserts events written by Export into the empty store in a single
+// transaction, keeping their seq and metadata. Nothing is imported if any line
+// is invalid: seqs must be increasing, stream versions must be consecutive in
+// each stream, and payloads must match the schema of the event names. Personal
+// data is encrypted with new data keys, and hashes are computed anew. Returns
+// the number of imported events.
+func Import(db *sql.DB, r io.Reader) (int, error) {
+	ctx := context.Background()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to begin transaction for events import: %s", err)
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM user_events)").Scan(&exists); err != nil {
+		return 0, fmt.Errorf("Failed to check existing events: %s", err)
+	}
+
+	if exists {
+		return 0, fmt.Errorf("Events can only be imported into an empty store")
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO user_events (
+		seq, payload, event_name, occurred_at, actor_id, correlation_id, causation_id,
+		stream_id, stream_version, schema_version
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to prepare INSERT statement for events import: %s", err)
+	}
+
+	scanner := bufio.NewScanner(r)
+	// Payloads may be larger than the default limit (64KiB).
+	scanner.Buffer(nil, 16*1024*1024)
+
+	prevSeq := 0
+	streamVersions := map[string]int{}
+	lineNumber := 0
+	imported := 0
+	for scanner.Scan() {
+		lineNumber++
+
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var line exportedEvent
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return 0, fmt.Errorf("Invalid event at line %d: %s", lineNumber, err)
+		}
+
+		if line.Seq <= prevSeq {
+			return 0, fmt.Errorf("Invalid event at line %d: seq=%d is not greater than seq=%d", lineNumber, line.Seq, prevSeq)
+		}
+		prevSeq = line.Seq
+
+		if line.StreamID != "" && line.StreamVersion != streamVersions[line.StreamID]+1 {
+			return 0, fmt.Errorf(
+				"Invalid event at line %d: version of stream %s is %d, expected %d",
+				lineNumber, line.StreamID, line.StreamVersion, streamVersions[line.StreamID]+1,
+			)
+		}
+		streamVersions[line.StreamID] = line.StreamVersion
+
+		mt, err := defaultRegistry.Resolve(line.Name)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid event at line %d: %w", lineNumber, err)
+		}
+
+		message := mt.New().Interface()
+		if err := protojson.Unmarshal(line.Payload, message); err != nil {
+			return 0, fmt.Errorf("Invalid %s payload at line %d: %s", line.Name, lineNumber, err)
+		}
+
+		eventName, err := defaultRegistry.Name(message)
+		if err != nil {
+			return 0, err
+		}
+
+		sealed, err := sealPersonalData(tx, message)
+		if err != nil {
+			return 0, err
+		}
+
+		data, err := proto.Marshal(sealed)
+		if err != nil {
+			return 0, fmt.Errorf("Serializing of %s failed: %s", eventName, err)
+		}
+
+		var occurredAt time.Time
+		if line.OccurredAt != nil {
+			occurredAt = *line.OccurredAt
+		}
+
+		_, err = stmt.Exec(
+			line.Seq,
+			data,
+			eventName,
+			nullTime(occurredAt),
+			nullString(line.ActorID),
+			nullString(line.CorrelationID),
+			nullString(line.CausationID),
+			nullString(line.StreamID),
+			sql.NullInt64{Int64: int64(line.StreamVersion), Valid: line.StreamID != ""},
+			defaultRegistry.SchemaVersion(message.ProtoReflect().Descriptor().FullName()),
+		)
+		if err != nil {
+			return 0, fmt.Errorf("Failed to INSERT %s at line %d: %s", eventName, lineNumber, err)
+		}
+
+		if err := hashInserted(tx, line.Seq); err != nil {
+			return 0, err
+		}
+
+		imported++
+	}
+
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("Failed to read events to import: %s", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("Failed to commit transaction for events import: %s", err)
+	}
+
+	hubFor(db).publish(prevSeq)
+
+	return imported, nil
+}
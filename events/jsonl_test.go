@@ -0,0 +1,112 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package events
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/event"
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/model"
+)
+
+func TestExportImport(t *testing.T) {
+	src := openDB(t)
+
+	metadata := Metadata{
+		OccurredAt:    time.UnixMilli(1700000000000),
+		ActorID:       "admin",
+		CorrelationID: "request",
+	}
+
+	if err := Insert(src, UserStream("foo"), metadata, []proto.Message{
+		&event.UserCreated{
+			Id:          proto.String("foo"),
+			DisplayName: proto.String("Foo"),
+			Email:       proto.String("foo@example.com"),
+		},
+		&event.RoleAssigned{UserId: proto.String("foo"), Role: model.Role_ROLE_ADMIN.Enum()},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var exported bytes.Buffer
+	if n, err := Export(src, &exported); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Errorf("Expected 2 exported events, got %d", n)
+	}
+
+	if !strings.Contains(exported.String(), `"email":"foo@example.com"`) {
+		t.Errorf("Expected payload in JSON with decrypted personal data, got %s", exported.String())
+	}
+
+	dst := openDB(t)
+	if n, err := Import(dst, bytes.NewReader(exported.Bytes())); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Errorf("Expected 2 imported events, got %d", n)
+	}
+
+	want, err := List(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := List(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range want {
+		if got[i].Seq != want[i].Seq || got[i].Name != want[i].Name ||
+			!got[i].Metadata.OccurredAt.Equal(want[i].Metadata.OccurredAt) ||
+			got[i].Metadata.ActorID != want[i].Metadata.ActorID ||
+			got[i].StreamID != want[i].StreamID || got[i].StreamVersion != want[i].StreamVersion ||
+			!proto.Equal(got[i].Message, want[i].Message) {
+			t.Errorf("Expected %v, got %v", want[i], got[i])
+		}
+	}
+
+	if _, err := Import(dst, bytes.NewReader(exported.Bytes())); err == nil {
+		t.Errorf("Expected import into non-empty store to fail")
+	}
+}
+
+func TestImportInvalid(t *testing.T) {
+	// Valid event, to make sure nothing is imported when a later line is invalid.
+	first := `{"seq":2,"name":"event.UserCreated","streamId":"user-foo","streamVersion":1,"payload":{"id":"foo"}}` + "\n"
+
+	for name, line := range map[string]string{
+		"decreasing seq":     `{"seq":1,"name":"event.UserCreated","payload":{"id":"bar"}}`,
+		"stream version gap": `{"seq":3,"name":"event.RoleAssigned","streamId":"user-foo","streamVersion":3,"payload":{}}`,
+		"unknown name":       `{"seq":3,"name":"event.Foo","payload":{}}`,
+		"unknown field":      `{"seq":3,"name":"event.UserCreated","payload":{"foo":"bar"}}`,
+		"malformed JSON":     `{"seq":3,`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			db := openDB(t)
+
+			if _, err := Import(db, strings.NewReader(first+line)); err == nil {
+				t.Errorf("Expected import to fail")
+			}
+
+			if seq, err := LatestSeq(db); err != nil {
+				t.Fatal(err)
+			} else if seq != 0 {
+				t.Errorf("Expected nothing to be imported, got seq=%d", seq)
+			}
+		})
+	}
+}
@@ -10,93 +10,99 @@
 package events
 
 import (
-	"context"
 	"database/sql"
 	"fmt"
+	"time"
+)
 
-	"google.golang.org/protobuf/proto"
+// List returns every event in user_events. As this loads the whole log into
+// memory, prefer Iterate unless the log is known to be small.
+func List(db *sql.DB) ([]Event, error) {
+	events := []Event{}
+	for event, err := range Iterate(db, IterateOptions{}) {
+		if err != nil {
+			return nil, err
+		}
 
-	"pocka.jp/x/event_sourcing_user_management_poc/gen/event"
-)
+		events = append(events, event)
+	}
+
+	return events, nil
+}
 
-func List(db *sql.DB) ([]proto.Message, error) {
-	ctx := context.Background()
+type Scanner interface {
+	Scan(dst ...any) error
+}
 
-	tx, err := db.BeginTx(ctx, nil)
+// ScanEvent reads a row of user_events. The row must consist of Columns.
+// When the payload cannot be decoded, ScanEvent returns the event without
+// Message along with the error. When the event does not match its hash,
+// ScanEvent returns the event along with an error wrapping ErrTampered.
+// Unlike Iterate, ScanEvent does not decrypt personal data.
+func ScanEvent(scanner Scanner) (Event, error) {
+	row, err := scanRow(scanner)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to begin transaction for listing user_events: %s", err)
+		return Event{}, err
 	}
-	defer tx.Rollback()
 
-	var rowCount int
-	if err := tx.QueryRow("SELECT count(*) FROM user_events").Scan(&rowCount); err != nil {
-		return nil, fmt.Errorf("Failed to count user_events: %s", err)
-	}
+	return row.decode()
+}
 
-	if rowCount == 0 {
-		return []proto.Message{}, nil
-	}
+// storedEvent is a row of user_events before decoding the payload.
+type storedEvent struct {
+	Event
+
+	payload       []byte
+	schemaVersion int
+
+	hash     []byte
+	prevHash []byte
+
+	// seq of the first hashed event. Invalid until the hash chain exists.
+	chainStart sql.NullInt64
+}
 
-	rows, err := tx.Query("SELECT seq, event_name, payload FROM user_events ORDER BY seq ASC")
+func scanRow(scanner Scanner) (storedEvent, error) {
+	var row storedEvent
+	var occurredAt sql.NullInt64
+	var actorID, correlationID, causationID sql.NullString
+	var streamID sql.NullString
+	var streamVersion sql.NullInt64
+	err := scanner.Scan(
+		&row.Seq, &row.Name, &row.payload, &occurredAt, &actorID, &correlationID, &causationID,
+		&streamID, &streamVersion, &row.schemaVersion, &row.hash, &row.prevHash,
+		&row.chainStart,
+	)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to SELECT user_events: %s", err)
+		return storedEvent{}, fmt.Errorf("Failed to scan user event: %s", err)
 	}
 
-	events := make([]proto.Message, rowCount)
-	for i := range events {
-		if !rows.Next() {
-			return nil, fmt.Errorf("Number of events is less than rowCount")
-		}
-
-		event, _, err := ScanEvent(rows)
-		if err != nil {
-			return nil, err
-		}
+	row.Metadata = Metadata{
+		ActorID:       actorID.String,
+		CorrelationID: correlationID.String,
+		CausationID:   causationID.String,
+	}
 
-		events[i] = event
+	if occurredAt.Valid {
+		row.Metadata.OccurredAt = time.UnixMilli(occurredAt.Int64)
 	}
 
-	return events, nil
-}
+	row.StreamID = streamID.String
+	row.StreamVersion = int(streamVersion.Int64)
 
-type Scanner interface {
-	Scan(dst ...any) error
+	return row, nil
 }
 
-func ScanEvent(scanner Scanner) (proto.Message, int, error) {
-	var seq int
-	var eventName string
-	var payload []byte
-	if err := scanner.Scan(&seq, &eventName, &payload); err != nil {
-		return nil, 0, fmt.Errorf("Failed to scan user event: %s", err)
+// decode returns the event with the decoded payload. When the event does not
+// match its hash, this returns the decoded event along with *TamperedError.
+func (row storedEvent) decode() (Event, error) {
+	message, err := defaultRegistry.Decode(row.Name, row.schemaVersion, row.payload)
+	if err != nil {
+		return row.Event, fmt.Errorf("Failed to decode event at seq=%d: %w", row.Seq, err)
 	}
 
-	switch eventName {
-	case "InitialAdminCreationPasswordCreated":
-		var event event.InitialAdminCreationPasswordCreated
-		if err := proto.Unmarshal(payload, &event); err != nil {
-			return nil, 0, fmt.Errorf("Illegal InitialAdminCreationPasswordCreated event: %s", err)
-		}
-		return &event, seq, nil
-	case "UserCreated":
-		var event event.UserCreated
-		if err := proto.Unmarshal(payload, &event); err != nil {
-			return nil, 0, fmt.Errorf("Illegal UserCreated event: %s", err)
-		}
-		return &event, seq, nil
-	case "PasswordLoginConfigured":
-		var event event.PasswordLoginConfigured
-		if err := proto.Unmarshal(payload, &event); err != nil {
-			return nil, 0, fmt.Errorf("Illegal PasswordLoginConfigured event: %s", err)
-		}
-		return &event, seq, nil
-	case "RoleAssigned":
-		var event event.RoleAssigned
-		if err := proto.Unmarshal(payload, &event); err != nil {
-			return nil, 0, fmt.Errorf("Illegal RoleAssigned event: %s", err)
-		}
-		return &event, seq, nil
-	default:
-		return nil, 0, fmt.Errorf("Unknown event in user_events: name=%s", eventName)
-	}
+	event := row.Event
+	event.Message = message
+
+	return event, row.verify()
 }
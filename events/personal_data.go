@@ -0,0 +1,319 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package events
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/event"
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/model"
+)
+
+// dataKey returns the data key of the user, creating one if the user has none.
+func dataKey(tx *sql.Tx, userID string) ([]byte, error) {
+	key := make([]byte, 32)
+
+	// rand.Read never returns an error.
+	rand.Read(key)
+
+	_, err := tx.Exec(
+		"INSERT OR IGNORE INTO user_data_keys (user_id, data_key, created_at) VALUES (?, ?, ?)",
+		userID, key, time.Now().UnixMilli(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create data key of user %s: %s", userID, err)
+	}
+
+	if err := tx.QueryRow("SELECT data_key FROM user_data_keys WHERE user_id = ?", userID).Scan(&key); err != nil {
+		return nil, fmt.Errorf("Failed to get data key of user %s: %s", userID, err)
+	}
+
+	return key, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// sealPersonalData returns a copy of the event with personal data moved to
+// encrypted_personal_data. Events other than UserCreated and SessionStarted
+// are returned as-is.
+func sealPersonalData(tx *sql.Tx, ev proto.Message) (proto.Message, error) {
+	switch v := ev.(type) {
+	case *event.UserCreated:
+		if v.DisplayName == nil && v.Email == nil {
+			return ev, nil
+		}
+
+		sealed := proto.CloneOf(v)
+		sealed.DisplayName = nil
+		sealed.Email = nil
+
+		ciphertext, err := encryptPersonalData(tx, v.GetId(), &model.PersonalData{
+			DisplayName: v.DisplayName,
+			Email:       v.Email,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		sealed.EncryptedPersonalData = ciphertext
+		return sealed, nil
+	case *event.SessionStarted:
+		if v.IpAddress == nil && v.UserAgent == nil {
+			return ev, nil
+		}
+
+		sealed := proto.CloneOf(v)
+		sealed.IpAddress = nil
+		sealed.UserAgent = nil
+
+		ciphertext, err := encryptPersonalData(tx, v.GetUserId(), &model.PersonalData{
+			IpAddress: v.IpAddress,
+			UserAgent: v.UserAgent,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		sealed.EncryptedPersonalData = ciphertext
+		return sealed, nil
+	default:
+		return ev, nil
+	}
+}
+
+// encryptPersonalData encrypts the personal data with the user's data key.
+func encryptPersonalData(tx *sql.Tx, userID string, data *model.PersonalData) ([]byte, error) {
+	key, err := dataKey(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := proto.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encode personal data of user %s: %s", userID, err)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encrypt personal data of user %s: %s", userID, err)
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	rand.Read(nonce)
+
+	// The user ID is authenticated so the data cannot be moved to another user.
+	return gcm.Seal(nonce, nonce, plaintext, []byte(userID)), nil
+}
+
+// personalDataOwner returns the ID of the user whose encrypted personal data
+// the event carries, or false if the event carries none.
+func personalDataOwner(ev proto.Message) (string, bool) {
+	switch v := ev.(type) {
+	case *event.UserCreated:
+		return v.GetId(), v.EncryptedPersonalData != nil
+	case *event.SessionStarted:
+		return v.GetUserId(), v.EncryptedPersonalData != nil
+	default:
+		return "", false
+	}
+}
+
+// loadDataKeys returns data keys of users whose encrypted personal data the
+// events carry. Erased users have no key.
+func loadDataKeys(q Queryer, events []Event) (map[string][]byte, error) {
+	keys := map[string][]byte{}
+
+	userIDs := []any{}
+	for _, ev := range events {
+		if userID, ok := personalDataOwner(ev.Message); ok {
+			userIDs = append(userIDs, userID)
+		}
+	}
+
+	if len(userIDs) == 0 {
+		return keys, nil
+	}
+
+	rows, err := q.QueryContext(
+		context.Background(),
+		"SELECT user_id, data_key FROM user_data_keys WHERE user_id IN (?"+strings.Repeat(", ?", len(userIDs)-1)+")",
+		userIDs...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get data keys: %s", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID string
+		var key []byte
+		if err := rows.Scan(&userID, &key); err != nil {
+			return nil, fmt.Errorf("Failed to scan data key: %s", err)
+		}
+
+		keys[userID] = key
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Failed to get data keys: %s", err)
+	}
+
+	return keys, nil
+}
+
+// openPersonalData replaces encrypted personal data of the event with
+// decrypted one, in place. Personal data of users without data key, which
+// are erased, is left empty.
+func openPersonalData(keys map[string][]byte, ev Event) error {
+	switch v := ev.Message.(type) {
+	case *event.UserCreated:
+		sealed := v.EncryptedPersonalData
+		v.EncryptedPersonalData = nil
+
+		data, err := decryptPersonalData(keys, v.GetId(), sealed, ev.Seq)
+		if err != nil || data == nil {
+			return err
+		}
+
+		v.DisplayName = data.DisplayName
+		v.Email = data.Email
+	case *event.SessionStarted:
+		sealed := v.EncryptedPersonalData
+		v.EncryptedPersonalData = nil
+
+		data, err := decryptPersonalData(keys, v.GetUserId(), sealed, ev.Seq)
+		if err != nil || data == nil {
+			return err
+		}
+
+		v.IpAddress = data.IpAddress
+		v.UserAgent = data.UserAgent
+	}
+
+	return nil
+}
+
+// decryptPersonalData decrypts personal data of the user in the event at seq.
+// Returns nil if there is no personal data or the user has no data key.
+func decryptPersonalData(keys map[string][]byte, userID string, sealed []byte, seq int) (*model.PersonalData, error) {
+	if sealed == nil {
+		return nil, nil
+	}
+
+	key, ok := keys[userID]
+	if !ok {
+		return nil, nil
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decrypt personal data at seq=%d: %s", seq, err)
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("Failed to decrypt personal data at seq=%d: ciphertext is too short", seq)
+	}
+
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(userID))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decrypt personal data at seq=%d: %s", seq, err)
+	}
+
+	var data model.PersonalData
+	if err := proto.Unmarshal(plaintext, &data); err != nil {
+		return nil, fmt.Errorf("Failed to decode personal data at seq=%d: %s", seq, err)
+	}
+
+	return &data, nil
+}
+
+var ErrPlaintextPersonalData = errors.New("Personal data is stored in plaintext")
+
+// PlaintextPersonalDataError is returned when events hold personal data of the
+// user in plaintext, which cannot be erased by destroying the data key.
+// This error wraps ErrPlaintextPersonalData.
+type PlaintextPersonalDataError struct {
+	UserID string
+	Seqs   []int
+}
+
+func (e *PlaintextPersonalDataError) Error() string {
+	seqs := make([]string, len(e.Seqs))
+	for i, seq := range e.Seqs {
+		seqs[i] = strconv.Itoa(seq)
+	}
+
+	return fmt.Sprintf("%s: user %s at seq=%s", ErrPlaintextPersonalData, e.UserID, strings.Join(seqs, ", "))
+}
+
+func (e *PlaintextPersonalDataError) Unwrap() error {
+	return ErrPlaintextPersonalData
+}
+
+// plaintextPersonalData returns seqs of events holding personal data of the
+// user in plaintext, which are written before encryption was introduced.
+func plaintextPersonalData(q Queryer, userID string) ([]int, error) {
+	seqs := []int{}
+	for ev, err := range Iterate(q, IterateOptions{Types: []string{"event.UserCreated"}, sealed: true}) {
+		if err != nil && ev.Message == nil {
+			return nil, err
+		}
+
+		created := ev.Message.(*event.UserCreated)
+		if created.GetId() == userID && (created.DisplayName != nil || created.Email != nil) {
+			seqs = append(seqs, ev.Seq)
+		}
+	}
+
+	return seqs, nil
+}
+
+// EraseUserData deletes the data key of the user and appends UserErased to the
+// user's stream in the transaction, only if the stream's current version equals
+// to expectedVersion. Personal data of the user in events cannot be decrypted
+// afterwards. Fails with *PlaintextPersonalDataError if events written before
+// encryption was introduced hold the user's personal data in plaintext, as
+// destroying the key does not erase them.
+func EraseUserData(tx *Tx, userID string, expectedVersion int, metadata Metadata) error {
+	seqs, err := plaintextPersonalData(tx, userID)
+	if err != nil {
+		return err
+	}
+
+	if len(seqs) > 0 {
+		return &PlaintextPersonalDataError{UserID: userID, Seqs: seqs}
+	}
+
+	if _, err := tx.Exec("DELETE FROM user_data_keys WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("Failed to delete data key of user %s: %s", userID, err)
+	}
+
+	return tx.InsertWithExpectedVersion(UserStream(userID), expectedVersion, metadata, []proto.Message{
+		&event.UserErased{UserId: proto.String(userID)},
+	})
+}
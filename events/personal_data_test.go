@@ -0,0 +1,140 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package events
+
+import (
+	"bytes"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/event"
+)
+
+func TestPersonalDataEncryption(t *testing.T) {
+	db := openDB(t)
+
+	for _, id := range []string{"foo", "bar"} {
+		if err := Insert(db, UserStream(id), Metadata{}, []proto.Message{
+			&event.UserCreated{
+				Id:          proto.String(id),
+				DisplayName: proto.String("Name of " + id),
+				Email:       proto.String(id + "@example.com"),
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rows, err := db.Query("SELECT payload FROM user_events")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for rows.Next() {
+		var payload []byte
+		if err := rows.Scan(&payload); err != nil {
+			t.Fatal(err)
+		}
+
+		if bytes.Contains(payload, []byte("@example.com")) {
+			t.Errorf("Expected email to be encrypted, got %q", payload)
+		}
+	}
+	rows.Close()
+
+	if err := Transact(db, func(tx *Tx) error {
+		return EraseUserData(tx, "foo", AnyVersion, Metadata{})
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := List(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list) != 3 {
+		t.Fatalf("Expected 3 events, got %d", len(list))
+	}
+
+	foo := list[0].Message.(*event.UserCreated)
+	if foo.DisplayName != nil || foo.Email != nil {
+		t.Errorf("Expected personal data of erased user to be empty, got %v", foo)
+	}
+
+	bar := list[1].Message.(*event.UserCreated)
+	if bar.GetDisplayName() != "Name of bar" || bar.GetEmail() != "bar@example.com" {
+		t.Errorf("Expected personal data to be decrypted, got %v", bar)
+	}
+
+	if erased, ok := list[2].Message.(*event.UserErased); !ok || erased.GetUserId() != "foo" {
+		t.Errorf("Expected UserErased for foo, got %v", list[2].Message)
+	}
+
+	tampered, err := VerifyChain(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tampered) != 0 {
+		t.Errorf("Expected erasure to keep the hash chain, got %v", tampered)
+	}
+}
+
+func TestSessionClientEncryption(t *testing.T) {
+	db := openDB(t)
+
+	if err := Insert(db, SessionStream("foo"), Metadata{}, []proto.Message{
+		&event.SessionStarted{
+			SessionId: proto.String("foo"),
+			UserId:    proto.String("bar"),
+			IpAddress: proto.String("192.0.2.1"),
+			UserAgent: proto.String("Test/1.0"),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var payload []byte
+	if err := db.QueryRow("SELECT payload FROM user_events").Scan(&payload); err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(payload, []byte("192.0.2.1")) || bytes.Contains(payload, []byte("Test/1.0")) {
+		t.Errorf("Expected client to be encrypted, got %q", payload)
+	}
+
+	list, err := List(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := list[0].Message.(*event.SessionStarted)
+	if started.GetIpAddress() != "192.0.2.1" || started.GetUserAgent() != "Test/1.0" || started.EncryptedPersonalData != nil {
+		t.Errorf("Expected client to be decrypted, got %v", started)
+	}
+
+	if err := Transact(db, func(tx *Tx) error {
+		return EraseUserData(tx, "bar", AnyVersion, Metadata{})
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err = List(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started = list[0].Message.(*event.SessionStarted)
+	if started.IpAddress != nil || started.UserAgent != nil {
+		t.Errorf("Expected client of erased user to be empty, got %v", started)
+	}
+}
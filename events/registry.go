@@ -0,0 +1,215 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package events
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+var ErrUnknownEvent = errors.New("Unknown event")
+
+// Registry maps event names stored in user_events to Protobuf message types.
+// Event names are fully-qualified Protobuf message names, such as "event.UserCreated".
+// Message types are resolved through protoregistry, thus generated Go code
+// for the message must be linked into the binary.
+type Registry struct {
+	mu sync.RWMutex
+
+	names map[protoreflect.FullName]struct{}
+
+	// Legacy (or renamed) event name to the current fully-qualified name.
+	aliases map[string]protoreflect.FullName
+
+	// upcasters[name][i] converts a payload of schema version i+1 to i+2.
+	upcasters map[protoreflect.FullName][]Upcaster
+}
+
+// Upcaster converts a payload of an event schema version to the next version.
+// Upcasters work on wire format bytes, as older payloads may not be decodable
+// with the current message definition.
+type Upcaster func(payload []byte) ([]byte, error)
+
+func NewRegistry() *Registry {
+	return &Registry{
+		names:     map[protoreflect.FullName]struct{}{},
+		aliases:   map[string]protoreflect.FullName{},
+		upcasters: map[protoreflect.FullName][]Upcaster{},
+	}
+}
+
+// Register allows the message type to be stored as an event.
+// Aliases are other names the message type was stored as in the past.
+func (r *Registry) Register(msg proto.Message, aliases ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	name := msg.ProtoReflect().Descriptor().FullName()
+
+	r.names[name] = struct{}{}
+	for _, alias := range aliases {
+		r.aliases[alias] = name
+	}
+}
+
+// RegisterPackage registers every message type in the Protobuf package.
+func (r *Registry) RegisterPackage(pkg protoreflect.FullName) {
+	protoregistry.GlobalFiles.RangeFilesByPackage(pkg, func(file protoreflect.FileDescriptor) bool {
+		messages := file.Messages()
+		for i := range messages.Len() {
+			mt, err := protoregistry.GlobalTypes.FindMessageByName(messages.Get(i).FullName())
+			if err != nil {
+				continue
+			}
+
+			r.Register(mt.New().Interface())
+		}
+
+		return true
+	})
+}
+
+// RegisterUpcaster registers an upcaster converting a payload of fromVersion
+// to fromVersion+1. Upcasters of a message type must be registered in order,
+// starting from version 1. The latest schema version of the message type is
+// the number of upcasters plus one.
+func (r *Registry) RegisterUpcaster(msg proto.Message, fromVersion int, upcaster Upcaster) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	name := msg.ProtoReflect().Descriptor().FullName()
+
+	if expected := len(r.upcasters[name]) + 1; fromVersion != expected {
+		panic(fmt.Sprintf(
+			"Upcaster for %s must be registered for version %d, got %d", name, expected, fromVersion,
+		))
+	}
+
+	r.upcasters[name] = append(r.upcasters[name], upcaster)
+}
+
+// SchemaVersion returns the latest schema version of the message type.
+func (r *Registry) SchemaVersion(name protoreflect.FullName) int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return len(r.upcasters[name]) + 1
+}
+
+// Name returns the event name to store the message as.
+func (r *Registry) Name(msg proto.Message) (string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	name := msg.ProtoReflect().Descriptor().FullName()
+	if _, ok := r.names[name]; !ok {
+		return "", fmt.Errorf("%w: %s is not registered as an event", ErrUnknownEvent, name)
+	}
+
+	return string(name), nil
+}
+
+// Resolve returns the message type for the stored event name.
+func (r *Registry) Resolve(eventName string) (protoreflect.MessageType, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	name, ok := r.aliases[eventName]
+	if !ok {
+		name = protoreflect.FullName(eventName)
+	}
+
+	if _, ok := r.names[name]; !ok {
+		return nil, fmt.Errorf("%w: name=%s", ErrUnknownEvent, eventName)
+	}
+
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: name=%s: %s", ErrUnknownEvent, eventName, err)
+	}
+
+	return mt, nil
+}
+
+// NamesOf returns every event name the message type may be stored as,
+// including aliases.
+func (r *Registry) NamesOf(name protoreflect.FullName) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := []string{string(name)}
+	for alias, target := range r.aliases {
+		if target == name {
+			names = append(names, alias)
+		}
+	}
+
+	return names
+}
+
+// Decode parses the payload of the stored event, upcasting it from
+// schemaVersion to the latest schema version.
+func (r *Registry) Decode(eventName string, schemaVersion int, payload []byte) (proto.Message, error) {
+	mt, err := r.Resolve(eventName)
+	if err != nil {
+		return nil, err
+	}
+
+	name := mt.Descriptor().FullName()
+
+	r.mu.RLock()
+	upcasters := r.upcasters[name]
+	r.mu.RUnlock()
+
+	if schemaVersion < 1 || schemaVersion > len(upcasters)+1 {
+		return nil, fmt.Errorf(
+			"Unsupported schema version of %s: version=%d latest=%d",
+			eventName, schemaVersion, len(upcasters)+1,
+		)
+	}
+
+	for v := schemaVersion; v <= len(upcasters); v++ {
+		payload, err = upcasters[v-1](payload)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to upcast %s from version %d: %s", eventName, v, err)
+		}
+	}
+
+	msg := mt.New().Interface()
+	if err := proto.Unmarshal(payload, msg); err != nil {
+		return nil, fmt.Errorf("Illegal %s event: %s", eventName, err)
+	}
+
+	return msg, nil
+}
+
+var defaultRegistry = NewRegistry()
+
+// Register registers the message type to the default registry.
+// Packages defining their own events should call this in init().
+func Register(msg proto.Message, aliases ...string) {
+	defaultRegistry.Register(msg, aliases...)
+}
+
+// RegisterPackage registers every message type in the Protobuf package to
+// the default registry.
+func RegisterPackage(pkg protoreflect.FullName) {
+	defaultRegistry.RegisterPackage(pkg)
+}
+
+// RegisterUpcaster registers the upcaster to the default registry.
+func RegisterUpcaster(msg proto.Message, fromVersion int, upcaster Upcaster) {
+	defaultRegistry.RegisterUpcaster(msg, fromVersion, upcaster)
+}
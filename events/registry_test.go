@@ -0,0 +1,137 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package events
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/event"
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/projection"
+)
+
+func TestFullyQualifiedName(t *testing.T) {
+	name, err := defaultRegistry.Name(&event.UserCreated{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name != "event.UserCreated" {
+		t.Errorf("Expected \"event.UserCreated\", got \"%s\"", name)
+	}
+}
+
+func TestDecodeLegacyName(t *testing.T) {
+	payload, err := proto.Marshal(&event.UserCreated{Id: proto.String("foo")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"UserCreated", "event.UserCreated"} {
+		msg, err := defaultRegistry.Decode(name, 1, payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ev, ok := msg.(*event.UserCreated)
+		if !ok {
+			t.Fatalf("Expected UserCreated for %s, got %T", name, msg)
+		}
+
+		if *ev.Id != "foo" {
+			t.Errorf("Expected ID \"foo\", got \"%s\"", *ev.Id)
+		}
+	}
+}
+
+func TestRejectNonEvent(t *testing.T) {
+	if _, err := defaultRegistry.Name(&projection.User{}); !errors.Is(err, ErrUnknownEvent) {
+		t.Errorf("Expected ErrUnknownEvent for projection.User, got %v", err)
+	}
+
+	if _, err := defaultRegistry.Decode("projection.User", 1, []byte{}); !errors.Is(err, ErrUnknownEvent) {
+		t.Errorf("Expected ErrUnknownEvent for projection.User, got %v", err)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	r := NewRegistry()
+
+	if _, err := r.Resolve("UserCreated"); !errors.Is(err, ErrUnknownEvent) {
+		t.Errorf("Expected ErrUnknownEvent for empty registry, got %v", err)
+	}
+
+	r.Register(&event.UserCreated{}, "OldUserCreated")
+
+	mt, err := r.Resolve("OldUserCreated")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mt.Descriptor().FullName() != "event.UserCreated" {
+		t.Errorf("Expected event.UserCreated, got %s", mt.Descriptor().FullName())
+	}
+
+	names := r.NamesOf("event.UserCreated")
+	if len(names) != 2 {
+		t.Errorf("Expected 2 names, got %v", names)
+	}
+}
+
+func TestUpcast(t *testing.T) {
+	r := NewRegistry()
+	r.Register(&event.UserCreated{})
+
+	// Version 2 stores emails in lower case.
+	r.RegisterUpcaster(&event.UserCreated{}, 1, func(payload []byte) ([]byte, error) {
+		var v1 event.UserCreated
+		if err := proto.Unmarshal(payload, &v1); err != nil {
+			return nil, err
+		}
+
+		v1.Email = proto.String(strings.ToLower(v1.GetEmail()))
+
+		return proto.Marshal(&v1)
+	})
+
+	if v := r.SchemaVersion("event.UserCreated"); v != 2 {
+		t.Errorf("Expected schema version 2, got %d", v)
+	}
+
+	payload, err := proto.Marshal(&event.UserCreated{Email: proto.String("Foo@Example.com")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := r.Decode("event.UserCreated", 1, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if email := msg.(*event.UserCreated).GetEmail(); email != "foo@example.com" {
+		t.Errorf("Expected upcasted email \"foo@example.com\", got \"%s\"", email)
+	}
+
+	msg, err = r.Decode("event.UserCreated", 2, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if email := msg.(*event.UserCreated).GetEmail(); email != "Foo@Example.com" {
+		t.Errorf("Expected latest version payload as-is, got \"%s\"", email)
+	}
+
+	if _, err := r.Decode("event.UserCreated", 3, payload); err == nil {
+		t.Error("Expected an error for unknown future version, got nil")
+	}
+}
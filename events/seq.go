@@ -0,0 +1,35 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package events
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// SeqAt returns the seq of the last event occurred at or before t, or 0 if
+// there is no such event. Events after the first one occurred after t are not
+// counted even if they are backdated, so the state at the seq has no event
+// occurred after t. Events without occurred_at (inserted before the column
+// exists) are skipped.
+func SeqAt(db *sql.DB, t time.Time) (int, error) {
+	var seq int
+	err := db.QueryRow(
+		"SELECT coalesce(max(seq), 0) FROM user_events WHERE occurred_at <= ?1"+
+			" AND seq < ifnull((SELECT min(seq) FROM user_events WHERE occurred_at > ?1), seq + 1)",
+		t.UnixMilli(),
+	).Scan(&seq)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to get seq at %s: %s", t, err)
+	}
+
+	return seq, nil
+}
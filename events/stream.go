@@ -0,0 +1,94 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package events
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+const (
+	// AnyVersion disables the stream version check.
+	AnyVersion = -1
+
+	// NoStream expects the stream to have no events yet.
+	NoStream = 0
+)
+
+// InitialAdminCreationPasswordStream is a stream for the one-time password
+// used to create the first admin user.
+const InitialAdminCreationPasswordStream = "initial_admin_creation_password"
+
+// UserStream returns a stream ID for the user.
+func UserStream(userID string) string {
+	return "user-" + userID
+}
+
+// SessionStream returns a stream ID for the login session.
+func SessionStream(sessionID string) string {
+	return "session-" + sessionID
+}
+
+var ErrConcurrencyConflict = errors.New("Concurrency conflict")
+
+// ConcurrencyConflictError is returned when a stream's version differs from
+// the one a writer expected. This error wraps ErrConcurrencyConflict.
+type ConcurrencyConflictError struct {
+	Stream   string
+	Expected int
+
+	// Version of the stream found at insertion. When another writer appended
+	// to the stream in the middle of the insertion, this is the version collided.
+	Actual int
+}
+
+func (e *ConcurrencyConflictError) Error() string {
+	return fmt.Sprintf(
+		"%s: stream=%s expected version=%d actual version=%d",
+		ErrConcurrencyConflict, e.Stream, e.Expected, e.Actual,
+	)
+}
+
+func (e *ConcurrencyConflictError) Unwrap() error {
+	return ErrConcurrencyConflict
+}
+
+// StreamVersion returns the number of events in the stream.
+func StreamVersion(db *sql.DB, stream string) (int, error) {
+	return streamVersion(db, stream)
+}
+
+type queryRower interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func streamVersion(q queryRower, stream string) (int, error) {
+	var version int
+	err := q.QueryRow(
+		"SELECT coalesce(max(stream_version), 0) FROM user_events WHERE stream_id = ?", stream,
+	).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to get version of stream %s: %s", stream, err)
+	}
+
+	return version, nil
+}
+
+// LastUnstreamedSeq returns the seq of the last event without stream, which is
+// inserted before stream support, or 0 if there is no such event.
+func LastUnstreamedSeq(db *sql.DB) (int, error) {
+	var seq int
+	if err := db.QueryRow("SELECT coalesce(max(seq), 0) FROM user_events WHERE stream_id IS NULL").Scan(&seq); err != nil {
+		return 0, fmt.Errorf("Failed to get seq of the last event without stream: %s", err)
+	}
+
+	return seq, nil
+}
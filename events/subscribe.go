@@ -0,0 +1,176 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package events
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+)
+
+// hub notifies subscriptions of commits to a database.
+type hub struct {
+	mu sync.Mutex
+
+	// Closed and replaced on every commit.
+	committed chan struct{}
+
+	// The largest seq known to be committed.
+	latest int
+}
+
+var hubs sync.Map
+
+func hubFor(db *sql.DB) *hub {
+	h, _ := hubs.LoadOrStore(db, &hub{committed: make(chan struct{})})
+	return h.(*hub)
+}
+
+func (h *hub) publish(seq int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.latest = max(h.latest, seq)
+	close(h.committed)
+	h.committed = make(chan struct{})
+}
+
+// next returns a channel closed on the next commit, and the largest seq
+// committed so far. SQLite serializes writers, thus every event up to the seq
+// is visible to readers.
+func (h *hub) next() (<-chan struct{}, int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.committed, h.latest
+}
+
+// LatestSeq returns the seq of the last event, or 0 if there is no event.
+func LatestSeq(db *sql.DB) (int, error) {
+	var seq int
+	if err := db.QueryRow("SELECT coalesce(max(seq), 0) FROM user_events").Scan(&seq); err != nil {
+		return 0, fmt.Errorf("Failed to get latest seq: %s", err)
+	}
+
+	return seq, nil
+}
+
+// Poll returns the latest seq in the database, and wakes subscriptions up if
+// the database has events they are not notified of, such as ones inserted by
+// other processes.
+func Poll(db *sql.DB) (int, error) {
+	latest, err := LatestSeq(db)
+	if err != nil {
+		return 0, err
+	}
+
+	h := hubFor(db)
+	if _, known := h.next(); latest > known {
+		h.publish(latest)
+	}
+
+	return latest, nil
+}
+
+// Handler processes a committed event. Returning an error stops the subscription.
+type Handler func(Event) error
+
+type SubscribeOptions struct {
+	// Smallest seq to deliver. Zero means the beginning of the log.
+	FromSeq int
+
+	// Fully-qualified event names to deliver. Empty means every event.
+	Types []string
+}
+
+// Subscription delivers committed events to a handler, one at a time and in
+// seq order, on its own goroutine.
+type Subscription struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// Subscribe starts delivering events from opts.FromSeq to the handler.
+// Events already in the log are delivered first (catch-up), then events
+// inserted by Insert are delivered as they are committed (live). Events
+// inserted by other processes are delivered once Poll finds them.
+// The subscription stops when ctx is canceled, Close is called, the handler
+// returns an error, or an event cannot be loaded.
+func Subscribe(ctx context.Context, db *sql.DB, opts SubscribeOptions, handler Handler) *Subscription {
+	ctx, cancel := context.WithCancel(ctx)
+
+	s := &Subscription{
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		defer close(s.done)
+		s.err = s.run(ctx, db, opts, handler)
+	}()
+
+	return s
+}
+
+func (s *Subscription) run(ctx context.Context, db *sql.DB, opts SubscribeOptions, handler Handler) error {
+	h := hubFor(db)
+	next := max(opts.FromSeq, 0)
+
+	for {
+		// Take the channel before reading, so commits during the read are not missed.
+		committed, latest := h.next()
+
+		for event, err := range Iterate(db, IterateOptions{FromSeq: next, Types: opts.Types}) {
+			if err != nil {
+				return err
+			}
+
+			if err := ctx.Err(); err != nil {
+				return nil
+			}
+
+			if err := handler(event); err != nil {
+				return fmt.Errorf("Subscription handler failed at seq=%d: %w", event.Seq, err)
+			}
+
+			next = event.Seq + 1
+		}
+
+		// Skip events filtered out by Types.
+		next = max(next, latest+1)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-committed:
+		}
+	}
+}
+
+// Done returns a channel closed when the subscription stops.
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the reason why the subscription stopped, or nil if it was
+// stopped by Close or the context. Only valid after Done is closed.
+func (s *Subscription) Err() error {
+	return s.err
+}
+
+// Close stops the subscription and waits for the handler to return.
+func (s *Subscription) Close() error {
+	s.cancel()
+	<-s.done
+
+	return s.err
+}
@@ -0,0 +1,116 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/event"
+)
+
+func receive(t *testing.T, ch <-chan Event) Event {
+	select {
+	case ev := <-ch:
+		return ev
+	case <-time.After(3 * time.Second):
+		t.Fatal("Timed out waiting for an event")
+		return Event{}
+	}
+}
+
+func TestSubscribeCatchUpThenLive(t *testing.T) {
+	db := openDB(t)
+
+	if err := Insert(db, "foo", Metadata{}, []proto.Message{
+		&event.UserCreated{Id: proto.String("foo")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan Event, 10)
+	sub := Subscribe(context.Background(), db, SubscribeOptions{}, func(ev Event) error {
+		ch <- ev
+		return nil
+	})
+	defer sub.Close()
+
+	if ev := receive(t, ch); ev.Seq != 1 {
+		t.Errorf("Expected catch-up event at seq 1, got %d", ev.Seq)
+	}
+
+	if err := Insert(db, "foo", Metadata{}, []proto.Message{
+		&event.RoleAssigned{UserId: proto.String("foo")},
+		&event.RoleAssigned{UserId: proto.String("foo")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if ev := receive(t, ch); ev.Seq != 2 {
+		t.Errorf("Expected live event at seq 2, got %d", ev.Seq)
+	}
+
+	if ev := receive(t, ch); ev.Seq != 3 {
+		t.Errorf("Expected live event at seq 3, got %d", ev.Seq)
+	}
+}
+
+func TestSubscribeTypes(t *testing.T) {
+	db := openDB(t)
+
+	ch := make(chan Event, 10)
+	sub := Subscribe(context.Background(), db, SubscribeOptions{
+		Types: []string{"event.RoleAssigned"},
+	}, func(ev Event) error {
+		ch <- ev
+		return nil
+	})
+	defer sub.Close()
+
+	if err := Insert(db, "foo", Metadata{}, []proto.Message{
+		&event.UserCreated{Id: proto.String("foo")},
+		&event.RoleAssigned{UserId: proto.String("foo")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if ev := receive(t, ch); ev.Seq != 2 {
+		t.Errorf("Expected RoleAssigned at seq 2, got %d", ev.Seq)
+	}
+}
+
+func TestSubscribeHandlerError(t *testing.T) {
+	db := openDB(t)
+
+	failure := errors.New("failure")
+	sub := Subscribe(context.Background(), db, SubscribeOptions{}, func(ev Event) error {
+		return failure
+	})
+
+	if err := Insert(db, "foo", Metadata{}, []proto.Message{
+		&event.UserCreated{Id: proto.String("foo")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-sub.Done():
+	case <-time.After(3 * time.Second):
+		t.Fatal("Timed out waiting for the subscription to stop")
+	}
+
+	if !errors.Is(sub.Err(), failure) {
+		t.Errorf("Expected handler error, got %v", sub.Err())
+	}
+}
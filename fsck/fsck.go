@@ -0,0 +1,121 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+// Package fsck finds events projections cannot apply correctly. Projections
+// silently skip such events, so they are not noticed otherwise.
+package fsck
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+
+	"pocka.jp/x/event_sourcing_user_management_poc/events"
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/event"
+	"pocka.jp/x/event_sourcing_user_management_poc/projections/users"
+)
+
+type Problem struct {
+	// Seq of the event having the problem.
+	Seq int
+
+	Message string
+}
+
+// CheckEvents walks every event and returns problems found, in seq order.
+// Returns an error only when events cannot be read.
+func CheckEvents(db *sql.DB) ([]Problem, error) {
+	problems := []Problem{}
+	report := func(seq int, format string, args ...any) {
+		problems = append(problems, Problem{Seq: seq, Message: fmt.Sprintf(format, args...)})
+	}
+
+	// Seq of UserCreated by user ID.
+	created := map[string]int{}
+
+	// Seq of UserCreated by normalized email.
+	emails := map[string]int{}
+
+	// Events referencing users, checked after every UserCreated is known.
+	type reference struct {
+		seq    int
+		name   string
+		userID string
+	}
+	references := []reference{}
+
+	prevSeq := 0
+	for ev, err := range events.Iterate(db, events.IterateOptions{}) {
+		// Iterate yields an event without seq only for database errors.
+		if err != nil && ev.Seq == 0 {
+			return nil, err
+		}
+
+		if ev.Seq != prevSeq+1 {
+			report(ev.Seq, "Missing events between seq=%d and seq=%d", prevSeq, ev.Seq)
+		}
+		prevSeq = ev.Seq
+
+		if errors.Is(err, events.ErrUnknownEvent) {
+			report(ev.Seq, "Unknown event name %q", ev.Name)
+			continue
+		} else if tampered := (*events.TamperedError)(nil); errors.As(err, &tampered) {
+			// The payload is decoded. Check it as well.
+			report(ev.Seq, "Event is tampered: %s", tampered.Reason)
+		} else if err != nil {
+			report(ev.Seq, "Undecodable %s payload: %s", ev.Name, err)
+			continue
+		}
+
+		switch v := ev.Message.(type) {
+		case *event.UserCreated:
+			if _, ok := created[v.GetId()]; !ok {
+				created[v.GetId()] = ev.Seq
+			} else {
+				report(ev.Seq, "User %s is already created at seq=%d", v.GetId(), created[v.GetId()])
+			}
+
+			// Erased users have no email.
+			email := users.NormalizeEmail(v.GetEmail())
+			if email == "" {
+				break
+			}
+
+			if seq, ok := emails[email]; ok {
+				report(ev.Seq, "Email %s is already used by a user created at seq=%d", email, seq)
+			} else {
+				emails[email] = ev.Seq
+			}
+		case *event.PasswordLoginConfigured:
+			references = append(references, reference{seq: ev.Seq, name: ev.Name, userID: v.GetUserId()})
+		case *event.RoleAssigned:
+			references = append(references, reference{seq: ev.Seq, name: ev.Name, userID: v.GetUserId()})
+		case *event.UserErased:
+			references = append(references, reference{seq: ev.Seq, name: ev.Name, userID: v.GetUserId()})
+		case *event.SessionStarted:
+			references = append(references, reference{seq: ev.Seq, name: ev.Name, userID: v.GetUserId()})
+		}
+	}
+
+	for _, ref := range references {
+		seq, ok := created[ref.userID]
+		if !ok {
+			report(ref.seq, "%s references user %q, which is never created", ref.name, ref.userID)
+		} else if seq > ref.seq {
+			report(ref.seq, "%s references user %s, which is created later at seq=%d", ref.name, ref.userID, seq)
+		}
+	}
+
+	slices.SortStableFunc(problems, func(a, b Problem) int {
+		return a.Seq - b.Seq
+	})
+
+	return problems, nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package fsck
+
+import (
+	"database/sql"
+	"slices"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	_ "modernc.org/sqlite"
+
+	"pocka.jp/x/event_sourcing_user_management_poc/events"
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/event"
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/model"
+	"pocka.jp/x/event_sourcing_user_management_poc/migrations"
+)
+
+func TestCheckEvents(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	defer db.Close()
+
+	if _, err := migrations.Apply(db); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := events.Insert(db, "test", events.Metadata{}, []proto.Message{
+		// 1
+		&event.UserCreated{Id: proto.String("foo"), Email: proto.String("foo@example.com")},
+		// 2
+		&event.RoleAssigned{UserId: proto.String("foo"), Role: model.Role_ROLE_ADMIN.Enum()},
+		// 3: deleted below
+		&event.UserCreated{Id: proto.String("bar"), Email: proto.String("bar@example.com")},
+		// 4
+		&event.UserCreated{Id: proto.String("baz"), Email: proto.String(" FOO@example.com")},
+		// 5
+		&event.PasswordLoginConfigured{UserId: proto.String("qux")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.Exec(
+		"DELETE FROM user_events WHERE seq = 3;" +
+			"INSERT INTO user_events (event_name, payload) VALUES ('event.Unknown', NULL);" +
+			"INSERT INTO user_events (event_name, payload) VALUES ('event.UserCreated', x'ff')",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := CheckEvents(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seqs := []int{}
+	for _, p := range problems {
+		seqs = append(seqs, p.Seq)
+	}
+
+	// Gap at 4, hash chain broken at 4, duplicate email at 4, unknown user
+	// at 5, unknown name at 6, undecodable payload at 7.
+	if !slices.Equal(seqs, []int{4, 4, 4, 5, 6, 7}) {
+		t.Errorf("Expected problems at [4 4 4 5 6 7], got %v", problems)
+	}
+}
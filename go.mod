@@ -14,6 +14,7 @@ go 1.24.1
 require (
 	github.com/charmbracelet/lipgloss v1.0.0
 	github.com/charmbracelet/log v0.4.1
+	github.com/google/uuid v1.6.0
 	golang.org/x/crypto v0.36.0
 	google.golang.org/protobuf v1.36.6
 	modernc.org/sqlite v1.36.3
@@ -24,7 +25,6 @@ require (
 	github.com/charmbracelet/x/ansi v0.4.2 // indirect
 	github.com/dustin/go-humanize v1.0.1 // indirect
 	github.com/go-logfmt/logfmt v0.6.0 // indirect
-	github.com/google/uuid v1.6.0 // indirect
 	github.com/lucasb-eyer/go-colorful v1.2.0 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/muesli/termenv v0.16.0 // indirect
@@ -0,0 +1,219 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+
+	"pocka.jp/x/event_sourcing_user_management_poc/commands"
+	"pocka.jp/x/event_sourcing_user_management_poc/events"
+	"pocka.jp/x/event_sourcing_user_management_poc/fsck"
+	"pocka.jp/x/event_sourcing_user_management_poc/projections"
+	"pocka.jp/x/event_sourcing_user_management_poc/projections/initial_admin_creation_password"
+	"pocka.jp/x/event_sourcing_user_management_poc/projections/sessions"
+	"pocka.jp/x/event_sourcing_user_management_poc/projections/users"
+	"pocka.jp/x/event_sourcing_user_management_poc/projections/users_table"
+)
+
+type snapshotProjection struct {
+	name       string
+	projection projections.Snapshottable
+}
+
+// snapshotProjections lists every projection stored as snapshots.
+var snapshotProjections = []snapshotProjection{
+	{name: "users", projection: users.Projection},
+	{name: "initial_admin_creation_password", projection: initial_admin_creation_password.Projection},
+	{name: "sessions", projection: sessions.Projection},
+}
+
+// tableProjections lists every projection materialized into a table.
+var tableProjections = []*projections.TableProjection{
+	users_table.Projection,
+}
+
+// pruneSnapshots deletes snapshots not kept by the retention, from every projection.
+func pruneSnapshots(db *sql.DB, retention projections.Retention, logger *log.Logger) error {
+	now := time.Now()
+
+	total := projections.PruneResult{}
+	for _, p := range snapshotProjections {
+		result, err := p.projection.Prune(db, retention, now)
+		if err != nil {
+			return err
+		}
+
+		logger.Infof("Pruned %d %s snapshots (%d bytes)", result.Deleted, p.name, result.Bytes)
+
+		total.Deleted += result.Deleted
+		total.Bytes += result.Bytes
+	}
+
+	logger.Infof("Reclaimed %d bytes of snapshot payloads in total", total.Bytes)
+
+	if total.Deleted == 0 {
+		return nil
+	}
+
+	// SQLite keeps deleted pages for reuse. VACUUM gives them back to
+	// the file system.
+	if _, err := db.Exec("VACUUM"); err != nil {
+		return fmt.Errorf("Failed to VACUUM database: %s", err)
+	}
+
+	return nil
+}
+
+type rebuildable interface {
+	Rebuild(db *sql.DB, progress func(done, total int)) (int, error)
+}
+
+// rebuildProjection replays every event into the projection from scratch, then
+// swaps the result in. A server using the same database keeps serving reads
+// from the old state during the replay.
+func rebuildProjection(db *sql.DB, name string, logger *log.Logger) error {
+	targets := map[string]rebuildable{}
+	for _, p := range snapshotProjections {
+		targets[p.name] = p.projection
+	}
+	for _, p := range tableProjections {
+		targets[p.Name] = p
+	}
+
+	target, ok := targets[name]
+	if !ok {
+		names := strings.Join(slices.Sorted(maps.Keys(targets)), ", ")
+		return fmt.Errorf("Unknown projection %q. Available projections: %s", name, names)
+	}
+
+	logger.Infof("Rebuilding %s projection...", name)
+
+	checkpoint, err := target.Rebuild(db, func(done, total int) {
+		if total == 0 {
+			return
+		}
+
+		logger.Infof("Rebuilding %s projection: %d/%d events (%d%%)", name, done, total, done*100/total)
+	})
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Rebuilt %s projection at seq=%d", name, checkpoint)
+
+	return nil
+}
+
+// checkIntegrity logs problems in events and snapshots, and returns the
+// number of problems found.
+func checkIntegrity(db *sql.DB, logger *log.Logger) (int, error) {
+	problems, err := fsck.CheckEvents(db)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, p := range problems {
+		logger.Warn(p.Message, "seq", p.Seq)
+	}
+
+	found := len(problems)
+
+	for _, p := range snapshotProjections {
+		mismatches, err := p.projection.CheckSnapshots(db)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, seq := range mismatches {
+			logger.Warn("Snapshot differs from events replayed from scratch", "projection", p.name, "seq", seq)
+		}
+
+		found += len(mismatches)
+	}
+
+	return found, nil
+}
+
+// eraseUser erases personal data of the user, for right-to-be-forgotten
+// requests received outside of the web UI.
+func eraseUser(db *sql.DB, userID string, logger *log.Logger) error {
+	if err := commands.EraseUser(db, events.Metadata{}, userID); err != nil {
+		return err
+	}
+
+	logger.Infof("Erased personal data of user %s", userID)
+
+	return nil
+}
+
+// exportEvents writes every event to the file as JSON Lines, or to stdout if
+// path is empty or "-".
+func exportEvents(db *sql.DB, path string, logger *log.Logger) error {
+	if path == "" || path == "-" {
+		exported, err := events.Export(db, os.Stdout)
+		if err != nil {
+			return err
+		}
+
+		logger.Infof("Exported %d events", exported)
+		return nil
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("Failed to create %s: %s", path, err)
+	}
+	defer f.Close()
+
+	exported, err := events.Export(db, f)
+	if err != nil {
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("Failed to write %s: %s", path, err)
+	}
+
+	logger.Infof("Exported %d events to %s", exported, path)
+
+	return nil
+}
+
+// importEvents inserts events in JSON Lines read from the file, or from stdin
+// if path is empty or "-", into the empty database.
+func importEvents(db *sql.DB, path string, logger *log.Logger) error {
+	r := os.Stdin
+	if path != "" && path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("Failed to open %s: %s", path, err)
+		}
+		defer f.Close()
+
+		r = f
+	}
+
+	imported, err := events.Import(db, r)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Imported %d events", imported)
+
+	return nil
+}
@@ -0,0 +1,23 @@
+-- Copyright 2025 Shota FUJI
+--
+-- This source code is licensed under Zero-Clause BSD License.
+-- You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+-- You may also obtain a copy of the Zero-Clause BSD License at
+-- <https://opensource.org/license/0bsd>
+--
+-- SPDX-License-Identifier: 0BSD
+
+-- Envelope metadata of events. These are NULL for events inserted before
+-- this migration.
+
+-- When did the event happen? Unix time in milliseconds.
+ALTER TABLE user_events ADD COLUMN occurred_at INTEGER;
+
+-- ID of the user who triggered the event.
+ALTER TABLE user_events ADD COLUMN actor_id TEXT;
+
+-- ID shared among events caused by a same request.
+ALTER TABLE user_events ADD COLUMN correlation_id TEXT;
+
+-- ID of the message (request, event) directly caused the event.
+ALTER TABLE user_events ADD COLUMN causation_id TEXT;
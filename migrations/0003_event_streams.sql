@@ -0,0 +1,19 @@
+-- Copyright 2025 Shota FUJI
+--
+-- This source code is licensed under Zero-Clause BSD License.
+-- You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+-- You may also obtain a copy of the Zero-Clause BSD License at
+-- <https://opensource.org/license/0bsd>
+--
+-- SPDX-License-Identifier: 0BSD
+
+-- Which stream (aggregate) does the event belong to? e.g. "user-<uuid>".
+-- NULL for events inserted before this migration.
+ALTER TABLE user_events ADD COLUMN stream_id TEXT;
+
+-- Position of the event in its stream, starting from 1.
+ALTER TABLE user_events ADD COLUMN stream_version INTEGER;
+
+-- Last line of defense against concurrent writers appending the same version.
+-- SQLite treats NULLs as distinct, so events without stream are not affected.
+CREATE UNIQUE INDEX user_events_stream ON user_events (stream_id, stream_version);
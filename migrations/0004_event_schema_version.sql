@@ -0,0 +1,12 @@
+-- Copyright 2025 Shota FUJI
+--
+-- This source code is licensed under Zero-Clause BSD License.
+-- You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+-- You may also obtain a copy of the Zero-Clause BSD License at
+-- <https://opensource.org/license/0bsd>
+--
+-- SPDX-License-Identifier: 0BSD
+
+-- Version of the payload schema at the time of insertion, starting from 1.
+-- Older payloads are converted to the latest schema by upcasters on read.
+ALTER TABLE user_events ADD COLUMN schema_version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,14 @@
+-- Copyright 2025 Shota FUJI
+--
+-- This source code is licensed under Zero-Clause BSD License.
+-- You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+-- You may also obtain a copy of the Zero-Clause BSD License at
+-- <https://opensource.org/license/0bsd>
+--
+-- SPDX-License-Identifier: 0BSD
+
+-- When was the snapshot taken? Unix time in milliseconds.
+-- Used by retention policy. NULL for snapshots taken before this migration.
+ALTER TABLE users_snapshots ADD COLUMN created_at INTEGER;
+
+ALTER TABLE initial_admin_creation_password_snapshots ADD COLUMN created_at INTEGER;
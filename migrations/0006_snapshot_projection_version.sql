@@ -0,0 +1,15 @@
+-- Copyright 2025 Shota FUJI
+--
+-- This source code is licensed under Zero-Clause BSD License.
+-- You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+-- You may also obtain a copy of the Zero-Clause BSD License at
+-- <https://opensource.org/license/0bsd>
+--
+-- SPDX-License-Identifier: 0BSD
+
+-- Fingerprint of the projection code and schema the snapshot was built with.
+-- Snapshots with a different fingerprint are ignored, thus existing snapshots
+-- (NULL) are rebuilt on the first read.
+ALTER TABLE users_snapshots ADD COLUMN projection_version TEXT;
+
+ALTER TABLE initial_admin_creation_password_snapshots ADD COLUMN projection_version TEXT;
@@ -0,0 +1,22 @@
+-- Copyright 2025 Shota FUJI
+--
+-- This source code is licensed under Zero-Clause BSD License.
+-- You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+-- You may also obtain a copy of the Zero-Clause BSD License at
+-- <https://opensource.org/license/0bsd>
+--
+-- SPDX-License-Identifier: 0BSD
+
+-- Progress of projections materialized into regular tables.
+-- Tables themselves are created by the projections.
+CREATE TABLE projection_checkpoints (
+	-- Name of the projection.
+	name TEXT PRIMARY KEY,
+
+	-- `seq` of the last event applied to the table.
+	event_seq INTEGER NOT NULL,
+
+	-- Version of the projection the table was built with. The table is
+	-- rebuilt from scratch when this differs from the projection's.
+	projection_version TEXT NOT NULL
+);
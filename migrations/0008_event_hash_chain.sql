@@ -0,0 +1,31 @@
+-- Copyright 2025 Shota FUJI
+--
+-- This source code is licensed under Zero-Clause BSD License.
+-- You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+-- You may also obtain a copy of the Zero-Clause BSD License at
+-- <https://opensource.org/license/0bsd>
+--
+-- SPDX-License-Identifier: 0BSD
+
+-- SHA-256 of the previous event's hash and this event's columns.
+-- Rewriting an event breaks the chain from the event.
+-- NULL for events inserted before this migration, until hash-legacy-events
+-- command hashes them. Missing hashes at or after the start of the chain
+-- (event_chain table) are reported as tampering.
+ALTER TABLE user_events ADD COLUMN hash BLOB;
+
+-- Event hashes signed with the server's ed25519 key.
+-- Rewriting the whole chain after a checkpoint is detected by the signature.
+CREATE TABLE event_checkpoints (
+	-- `seq` of the signed event.
+	event_seq INTEGER PRIMARY KEY ON CONFLICT ROLLBACK,
+
+	-- `hash` of the signed event at the time of signing.
+	hash BLOB NOT NULL,
+
+	-- ed25519 signature of the checkpoint.
+	signature BLOB NOT NULL,
+
+	-- Unix time in milliseconds.
+	created_at INTEGER NOT NULL
+);
@@ -0,0 +1,21 @@
+-- Copyright 2025 Shota FUJI
+--
+-- This source code is licensed under Zero-Clause BSD License.
+-- You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+-- You may also obtain a copy of the Zero-Clause BSD License at
+-- <https://opensource.org/license/0bsd>
+--
+-- SPDX-License-Identifier: 0BSD
+
+-- Keys encrypting personal data in events, one per user.
+-- Deleting a row makes the user's personal data unreadable (crypto-shredding),
+-- without rewriting events.
+CREATE TABLE user_data_keys (
+	user_id TEXT PRIMARY KEY ON CONFLICT ROLLBACK,
+
+	-- AES-256 key.
+	data_key BLOB NOT NULL,
+
+	-- Unix time in milliseconds.
+	created_at INTEGER NOT NULL
+);
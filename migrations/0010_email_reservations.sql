@@ -0,0 +1,23 @@
+-- Copyright 2025 Shota FUJI
+--
+-- This source code is licensed under Zero-Clause BSD License.
+-- You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+-- You may also obtain a copy of the Zero-Clause BSD License at
+-- <https://opensource.org/license/0bsd>
+--
+-- SPDX-License-Identifier: 0BSD
+
+-- Emails in use, updated in the same transaction as events creating or erasing
+-- users, so two users cannot have the same email even if they are created
+-- concurrently.
+CREATE TABLE email_reservations (
+	-- Normalized (trimmed and lower-cased) email.
+	email TEXT PRIMARY KEY,
+
+	user_id TEXT NOT NULL,
+
+	-- Unix time in milliseconds.
+	reserved_at INTEGER NOT NULL
+);
+
+CREATE INDEX email_reservations_user_id ON email_reservations (user_id);
@@ -0,0 +1,19 @@
+-- Copyright 2025 Shota FUJI
+--
+-- This source code is licensed under Zero-Clause BSD License.
+-- You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+-- You may also obtain a copy of the Zero-Clause BSD License at
+-- <https://opensource.org/license/0bsd>
+--
+-- SPDX-License-Identifier: 0BSD
+
+CREATE TABLE sessions_snapshots (
+	-- Which event is this snapshot taken at?
+	event_seq INTEGER PRIMARY KEY ON CONFLICT ROLLBACK,
+	-- Protobuf wire format
+	payload BLOB,
+	-- Unix time in milliseconds.
+	created_at INTEGER,
+	-- See 0006_snapshot_projection_version.sql
+	projection_version TEXT
+);
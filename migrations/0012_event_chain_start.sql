@@ -0,0 +1,30 @@
+-- Copyright 2025 Shota FUJI
+--
+-- This source code is licensed under Zero-Clause BSD License.
+-- You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+-- You may also obtain a copy of the Zero-Clause BSD License at
+-- <https://opensource.org/license/0bsd>
+--
+-- SPDX-License-Identifier: 0BSD
+
+-- Where the hash chain of events starts. Every event at or after `start_seq`
+-- must have a hash: an event without one is a tampered one. Events before it
+-- are inserted before the hash chain exists, and are not verified.
+-- Has no row until the first event is hashed.
+CREATE TABLE event_chain (
+	-- The table has at most one row.
+	id INTEGER PRIMARY KEY CHECK (id = 1),
+
+	-- `seq` of the first hashed event.
+	start_seq INTEGER NOT NULL,
+
+	-- Unix time in milliseconds.
+	created_at INTEGER NOT NULL
+);
+
+-- Databases hashed before this migration.
+INSERT INTO event_chain (id, start_seq, created_at)
+	SELECT 1, min(seq), CAST(unixepoch('subsec') * 1000 AS INTEGER)
+	FROM user_events
+	WHERE hash IS NOT NULL
+	HAVING count(*) > 0;
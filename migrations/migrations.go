@@ -0,0 +1,236 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+// Package migrations applies versioned SQL files embedded in this package.
+//
+// Each file is named "<version>_<name>.sql", where version is a positive
+// integer. Migrations are forward-only: once applied, a migration is never
+// rolled back nor re-applied. To change schema, add a new file with a greater
+// version instead of editing existing ones.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// List returns every embedded migration, ordered by version.
+func List() ([]Migration, error) {
+	entries, err := fs.Glob(files, "*.sql")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list migration files: %s", err)
+	}
+
+	migrations := make([]Migration, 0, len(entries))
+	for _, entry := range entries {
+		version, name, ok := strings.Cut(strings.TrimSuffix(entry, ".sql"), "_")
+		if !ok {
+			return nil, fmt.Errorf("Migration file name must be <version>_<name>.sql: %s", entry)
+		}
+
+		v, err := strconv.Atoi(version)
+		if err != nil || v <= 0 {
+			return nil, fmt.Errorf("Illegal migration version in %s", entry)
+		}
+
+		content, err := files.ReadFile(entry)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read migration file %s: %s", entry, err)
+		}
+
+		migrations = append(migrations, Migration{
+			Version: v,
+			Name:    name,
+			SQL:     string(content),
+		})
+	}
+
+	slices.SortFunc(migrations, func(a, b Migration) int {
+		return a.Version - b.Version
+	})
+
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i-1].Version == migrations[i].Version {
+			return nil, fmt.Errorf("Duplicate migration version: %d", migrations[i].Version)
+		}
+	}
+
+	return migrations, nil
+}
+
+// CurrentVersion returns the version of the latest applied migration.
+// Returns 0 if no migration has been applied yet.
+func CurrentVersion(db *sql.DB) (int, error) {
+	if err := ensureTable(db); err != nil {
+		return 0, err
+	}
+
+	var version int
+	err := db.QueryRow("SELECT coalesce(max(version), 0) FROM schema_migrations").Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to get current schema version: %s", err)
+	}
+
+	return version, nil
+}
+
+// Apply applies every migration not yet recorded in "schema_migrations" table,
+// in version order. Each migration runs in its own transaction.
+// Returns the migrations applied by this call.
+func Apply(db *sql.DB) ([]Migration, error) {
+	migrations, err := List()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ensureTable(db); err != nil {
+		return nil, err
+	}
+
+	if err := baselineLegacySchema(db); err != nil {
+		return nil, err
+	}
+
+	current, err := CurrentVersion(db)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(migrations) > 0 && current > migrations[len(migrations)-1].Version {
+		return nil, fmt.Errorf(
+			"Database schema version %d is newer than the latest known migration %d",
+			current, migrations[len(migrations)-1].Version,
+		)
+	}
+
+	applied := []Migration{}
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+
+		if err := apply(db, m); err != nil {
+			return applied, err
+		}
+
+		applied = append(applied, m)
+	}
+
+	return applied, nil
+}
+
+func apply(db *sql.DB, m Migration) error {
+	ctx := context.Background()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Failed to begin transaction for migration %d: %s", m.Version, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(m.SQL); err != nil {
+		return fmt.Errorf("Migration %d (%s) failed: %s", m.Version, m.Name, err)
+	}
+
+	if err := record(tx, m); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Failed to commit migration %d: %s", m.Version, err)
+	}
+
+	return nil
+}
+
+func record(tx *sql.Tx, m Migration) error {
+	_, err := tx.Exec(
+		"INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+		m.Version, m.Name, time.Now().UTC().Format(time.RFC3339),
+	)
+	if err != nil {
+		return fmt.Errorf("Failed to record migration %d: %s", m.Version, err)
+	}
+
+	return nil
+}
+
+func ensureTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TEXT NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("Failed to create schema_migrations table: %s", err)
+	}
+
+	return nil
+}
+
+// Databases created before the migrations subsystem have tables from the first
+// migration but no record of it. Record the first migration as applied so it
+// won't fail with "table already exists".
+func baselineLegacySchema(db *sql.DB) error {
+	ctx := context.Background()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Failed to begin transaction for legacy schema detection: %s", err)
+	}
+	defer tx.Rollback()
+
+	var recorded int
+	if err := tx.QueryRow("SELECT count(*) FROM schema_migrations").Scan(&recorded); err != nil {
+		return fmt.Errorf("Failed to count schema_migrations: %s", err)
+	}
+
+	if recorded > 0 {
+		return nil
+	}
+
+	var legacyTables int
+	err = tx.QueryRow(
+		"SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'user_events'",
+	).Scan(&legacyTables)
+	if err != nil {
+		return fmt.Errorf("Failed to check legacy schema: %s", err)
+	}
+
+	if legacyTables == 0 {
+		return nil
+	}
+
+	migrations, err := List()
+	if err != nil {
+		return err
+	}
+
+	if err := record(tx, migrations[0]); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,114 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package migrations
+
+import (
+	"database/sql"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+func openDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func TestApplyAll(t *testing.T) {
+	db := openDB(t)
+
+	all, err := List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := Apply(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(applied) != len(all) {
+		t.Errorf("Expected %d migrations to be applied, got %d", len(all), len(applied))
+	}
+
+	version, err := CurrentVersion(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if version != all[len(all)-1].Version {
+		t.Errorf("Expected version %d, got %d", all[len(all)-1].Version, version)
+	}
+}
+
+func TestApplyTwice(t *testing.T) {
+	db := openDB(t)
+
+	if _, err := Apply(db); err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := Apply(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(applied) != 0 {
+		t.Errorf("Expected no migrations to be applied, got %d", len(applied))
+	}
+}
+
+func TestLegacySchema(t *testing.T) {
+	db := openDB(t)
+
+	all, err := List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Database created by running the first migration directly, like the
+	// server did before the migrations subsystem.
+	if _, err := db.Exec(all[0].SQL); err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := Apply(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(applied) != len(all)-1 {
+		t.Errorf("Expected %d migrations to be applied, got %d", len(all)-1, len(applied))
+	}
+}
+
+func TestNewerSchema(t *testing.T) {
+	db := openDB(t)
+
+	if _, err := Apply(db); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec(
+		"INSERT INTO schema_migrations (version, name, applied_at) VALUES (9999, 'future', '')",
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Apply(db); err == nil {
+		t.Error("Expected an error for unknown schema version, got nil")
+	}
+}
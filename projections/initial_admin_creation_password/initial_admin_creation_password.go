@@ -10,63 +10,28 @@
 package initial_admin_creation_password
 
 import (
-	"context"
 	"database/sql"
-	"fmt"
 
 	"google.golang.org/protobuf/proto"
 
-	"pocka.jp/x/event_sourcing_user_management_poc/events"
 	"pocka.jp/x/event_sourcing_user_management_poc/gen/event"
 	"pocka.jp/x/event_sourcing_user_management_poc/gen/model"
 	"pocka.jp/x/event_sourcing_user_management_poc/gen/projection"
+	"pocka.jp/x/event_sourcing_user_management_poc/projections"
 )
 
-func GetProjection(db *sql.DB) (*projection.InitialAdminCreationPassword, int, error) {
-	ctx := context.Background()
-
-	var p projection.InitialAdminCreationPassword
-
-	tx, err := db.BeginTx(ctx, nil)
-	if err != nil {
-		return nil, 0, fmt.Errorf("Failed to begin transaction for InitialAdminCreationPasswordProjection: %s", err)
-	}
-	defer tx.Rollback()
-
-	var eventSeq int
-	var payload []byte
-
-	err = tx.QueryRow("SELECT event_seq, payload FROM initial_admin_creation_password_snapshots ORDER BY event_seq DESC LIMIT 1").Scan(&eventSeq, &payload)
-	if err == sql.ErrNoRows {
-		p = projection.InitialAdminCreationPassword{}
-		eventSeq = -1
-	} else if err != nil {
-		return nil, 0, fmt.Errorf("Failed to get latest snapshot: %s", err)
-	} else {
-		if err := proto.Unmarshal(payload, &p); err != nil {
-			return nil, 0, fmt.Errorf("Failed to decode latest snapshot: %s", err)
-		}
-	}
-
-	stmt, err := tx.Prepare("SELECT seq, event_name, payload FROM user_events WHERE seq > ? ORDER BY seq ASC")
-	if err != nil {
-		return nil, 0, fmt.Errorf("Failed to prepare event fetching query: %s", err)
-	}
-
-	maxSeq := -1
-	rows, err := stmt.Query(eventSeq)
-	for rows.Next() {
-		ev, seq, err := events.ScanEvent(rows)
-		if err != nil {
-			return nil, 0, err
-		}
-
-		maxSeq = max(maxSeq, seq)
-
-		apply(ev, &p)
-	}
+var Projection = &projections.Projection[*projection.InitialAdminCreationPassword]{
+	Name:          "initial_admin_creation_password",
+	SnapshotTable: "initial_admin_creation_password_snapshots",
+	New: func() *projection.InitialAdminCreationPassword {
+		return &projection.InitialAdminCreationPassword{}
+	},
+	Apply:   apply,
+	Version: "1",
+}
 
-	return &p, maxSeq, nil
+func GetProjection(db *sql.DB) (*projection.InitialAdminCreationPassword, int, error) {
+	return Projection.Get(db)
 }
 
 func apply(e proto.Message, p *projection.InitialAdminCreationPassword) {
@@ -81,24 +46,3 @@ func apply(e proto.Message, p *projection.InitialAdminCreationPassword) {
 		}
 	}
 }
-
-func SaveSnapshot(db *sql.DB) error {
-	p, seq, err := GetProjection(db)
-	if err != nil {
-		return err
-	}
-
-	stmt, err := db.Prepare("INSERT OR ABORT INTO initial_admin_creation_password_snapshots (event_seq, payload) VALUES (?, ?)")
-	if err != nil {
-		return err
-	}
-
-	payload, err := proto.Marshal(p)
-	if err != nil {
-		return err
-	}
-
-	_, err = stmt.Exec(seq, payload)
-
-	return err
-}
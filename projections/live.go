@@ -0,0 +1,118 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package projections
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+
+	"pocka.jp/x/event_sourcing_user_management_poc/events"
+)
+
+var ErrLiveStopped = errors.New("Live projection stopped")
+
+// Live keeps the state of a projection in memory, and applies events to it
+// as they are committed.
+type Live[T proto.Message] struct {
+	db  *sql.DB
+	sub *events.Subscription
+
+	mu         sync.RWMutex
+	state      T
+	checkpoint int
+	stopped    bool
+
+	// Closed and replaced whenever the state is updated or the subscription stops.
+	updated chan struct{}
+}
+
+// StartLive loads the current state of the projection, then keeps it up to
+// date until ctx is canceled.
+func StartLive[T proto.Message](ctx context.Context, db *sql.DB, p *Projection[T]) (*Live[T], error) {
+	state, checkpoint, err := p.Get(db)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Live[T]{
+		db:         db,
+		state:      state,
+		checkpoint: checkpoint,
+		updated:    make(chan struct{}),
+	}
+
+	l.sub = events.Subscribe(ctx, db, events.SubscribeOptions{FromSeq: checkpoint + 1}, func(ev events.Event) error {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		p.Apply(ev.Message, l.state)
+		l.checkpoint = ev.Seq
+
+		close(l.updated)
+		l.updated = make(chan struct{})
+		return nil
+	})
+
+	go func() {
+		<-l.sub.Done()
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		l.stopped = true
+		close(l.updated)
+	}()
+
+	return l, nil
+}
+
+// Subscription returns the subscription feeding events to the state.
+func (l *Live[T]) Subscription() *events.Subscription {
+	return l.sub
+}
+
+// View calls fn with the state and its checkpoint, once every event in the
+// database before the call is applied, including ones inserted by other
+// processes. Thus a reader sees its own writes. fn must not modify the state
+// nor keep references to it after return, as the state is updated in place.
+func (l *Live[T]) View(ctx context.Context, fn func(state T, checkpoint int)) error {
+	target, err := events.Poll(l.db)
+	if err != nil {
+		return err
+	}
+
+	for {
+		l.mu.RLock()
+
+		if l.checkpoint >= target {
+			fn(l.state, l.checkpoint)
+			l.mu.RUnlock()
+			return nil
+		}
+
+		if l.stopped {
+			l.mu.RUnlock()
+			return ErrLiveStopped
+		}
+
+		updated := l.updated
+		l.mu.RUnlock()
+
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
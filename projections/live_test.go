@@ -0,0 +1,111 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package projections
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/projection"
+	"pocka.jp/x/event_sourcing_user_management_poc/migrations"
+)
+
+func TestLiveReadYourWrites(t *testing.T) {
+	db := openDB(t)
+
+	createUser(t, db, "foo")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	live, err := StartLive(ctx, db, userIDs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, id := range []string{"bar", "baz"} {
+		createUser(t, db, id)
+
+		// No wait: View must see the event committed above.
+		err := live.View(context.Background(), func(p *projection.UsersProjection, checkpoint int) {
+			if len(p.Users) != i+2 || p.Users[i+1].GetId() != id || checkpoint != i+2 {
+				t.Errorf("Expected %s at %d, got %v at %d", id, i+2, p.Users, checkpoint)
+			}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLiveStopped(t *testing.T) {
+	db := openDB(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	live, err := StartLive(ctx, db, userIDs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	<-live.Subscription().Done()
+
+	createUser(t, db, "foo")
+
+	err = live.View(context.Background(), func(*projection.UsersProjection, int) {
+		t.Error("Expected View not to call fn for a stale state")
+	})
+	if !errors.Is(err, ErrLiveStopped) {
+		t.Errorf("Expected ErrLiveStopped, got %v", err)
+	}
+}
+
+func TestLiveOtherProcess(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	// Each *sql.DB has its own subscriptions, as if it were another process.
+	open := func() *sql.DB {
+		db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+
+		return db
+	}
+
+	db := open()
+	if _, err := migrations.Apply(db); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	live, err := StartLive(ctx, db, userIDs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	createUser(t, open(), "foo")
+
+	err = live.View(context.Background(), func(p *projection.UsersProjection, checkpoint int) {
+		if len(p.Users) != 1 || p.Users[0].GetId() != "foo" || checkpoint != 1 {
+			t.Errorf("Expected foo at 1, got %v at %d", p.Users, checkpoint)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -8,3 +8,360 @@
 // SPDX-License-Identifier: 0BSD
 
 package projections
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"hash"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"pocka.jp/x/event_sourcing_user_management_poc/events"
+)
+
+// Projection builds a read model of type T from events. The latest state is
+// saved as a snapshot so later reads only replay events after the snapshot.
+type Projection[T proto.Message] struct {
+	// Human readable name, used in logs and error messages.
+	Name string
+
+	// Table storing snapshots. The table must have "event_seq" (INTEGER PRIMARY KEY),
+	// "payload" (BLOB), "created_at" (INTEGER) and "projection_version" (TEXT) columns.
+	SnapshotTable string
+
+	// New returns an initial state, before any event.
+	New func() T
+
+	// Apply updates the state by the event.
+	Apply func(ev proto.Message, state T)
+
+	// Version of Apply. Bump this whenever Apply changes the way it builds
+	// the state, so snapshots built by the older Apply are ignored.
+	// Changes to the state's Protobuf schema are detected automatically.
+	Version string
+
+	fingerprintOnce sync.Once
+	fingerprint     string
+}
+
+// Fingerprint identifies the projection code and schema snapshots are built with.
+// It consists of Version and a hash of the state's Protobuf message descriptors.
+func (p *Projection[T]) Fingerprint() string {
+	p.fingerprintOnce.Do(func() {
+		h := sha256.New()
+		hashDescriptor(h, p.New().ProtoReflect().Descriptor(), map[protoreflect.FullName]struct{}{})
+
+		p.fingerprint = fmt.Sprintf("%s-%x", p.Version, h.Sum(nil)[:8])
+	})
+
+	return p.fingerprint
+}
+
+func hashDescriptor(h hash.Hash, md protoreflect.MessageDescriptor, seen map[protoreflect.FullName]struct{}) {
+	if _, ok := seen[md.FullName()]; ok {
+		return
+	}
+	seen[md.FullName()] = struct{}{}
+
+	// Marshal never fails for descriptor protos.
+	b, _ := proto.MarshalOptions{Deterministic: true}.Marshal(protodesc.ToDescriptorProto(md))
+	h.Write(b)
+
+	fields := md.Fields()
+	for i := range fields.Len() {
+		if fields.Get(i).Message() != nil {
+			hashDescriptor(h, fields.Get(i).Message(), seen)
+		}
+	}
+}
+
+// Get returns the current state and checkpoint, which is the seq of the last
+// event reflected in the state. The checkpoint is 0 if there is no event.
+func (p *Projection[T]) Get(db *sql.DB) (T, int, error) {
+	state, checkpoint, _, err := p.load(db, 0)
+	return state, checkpoint, err
+}
+
+// GetAt returns the state right after the event at seq was applied, and
+// the checkpoint. The checkpoint is less than seq if seq is not committed yet.
+func (p *Projection[T]) GetAt(db *sql.DB, seq int) (T, int, error) {
+	if seq <= 0 {
+		return p.New(), 0, nil
+	}
+
+	state, checkpoint, _, err := p.load(db, seq)
+	return state, checkpoint, err
+}
+
+// GetAsOf returns the state at the time t, and the checkpoint.
+func (p *Projection[T]) GetAsOf(db *sql.DB, t time.Time) (T, int, error) {
+	seq, err := events.SeqAt(db, t)
+	if err != nil {
+		var zero T
+		return zero, 0, err
+	}
+
+	return p.GetAt(db, seq)
+}
+
+// load returns the state at toSeq (0 for the latest), the checkpoint, and
+// the seq of the snapshot the state is built on.
+func (p *Projection[T]) load(db *sql.DB, toSeq int) (T, int, int, error) {
+	tx, err := db.BeginTx(context.Background(), nil)
+	if err != nil {
+		var zero T
+		return zero, 0, 0, fmt.Errorf("Failed to begin transaction for %s projection: %s", p.Name, err)
+	}
+	defer tx.Rollback()
+
+	return p.loadTx(tx, toSeq)
+}
+
+// loadTx is load in the transaction.
+func (p *Projection[T]) loadTx(tx *sql.Tx, toSeq int) (T, int, int, error) {
+	var zero T
+
+	state := p.New()
+
+	var snapshotSeq int
+	var payload []byte
+
+	query := "SELECT event_seq, payload FROM " + p.SnapshotTable + " WHERE projection_version = ?"
+	args := []any{p.Fingerprint()}
+	if toSeq > 0 {
+		query += " AND event_seq <= ?"
+		args = append(args, toSeq)
+	}
+
+	// Snapshots built by other versions may have wrong state. Ignoring them
+	// rebuilds the state from scratch, or from a snapshot of this version.
+	err := tx.QueryRow(query+" ORDER BY event_seq DESC LIMIT 1", args...).Scan(&snapshotSeq, &payload)
+	if err == sql.ErrNoRows {
+		snapshotSeq = 0
+	} else if err != nil {
+		return zero, 0, 0, fmt.Errorf("Failed to get latest %s snapshot: %s", p.Name, err)
+	} else {
+		if err := proto.Unmarshal(payload, state); err != nil {
+			return zero, 0, 0, fmt.Errorf("Failed to decode %s snapshot at %d: %s", p.Name, snapshotSeq, err)
+		}
+	}
+
+	checkpoint := snapshotSeq
+	for ev, err := range events.Iterate(tx, events.IterateOptions{FromSeq: snapshotSeq + 1, ToSeq: toSeq}) {
+		if err != nil {
+			return zero, 0, 0, err
+		}
+
+		p.Apply(ev.Message, state)
+		checkpoint = ev.Seq
+	}
+
+	return state, checkpoint, snapshotSeq, nil
+}
+
+// SaveSnapshot saves the current state as a snapshot. Does nothing if there
+// is no event since the latest snapshot. Returns whether a snapshot is saved.
+// The state is loaded and saved in a single transaction, so a state loaded
+// before snapshots are deleted (e.g. by erasure of personal data) is never
+// saved after the deletion.
+func (p *Projection[T]) SaveSnapshot(db *sql.DB) (bool, error) {
+	tx, err := db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return false, fmt.Errorf("Failed to begin transaction for %s snapshot: %s", p.Name, err)
+	}
+	defer tx.Rollback()
+
+	state, checkpoint, snapshotSeq, err := p.loadTx(tx, 0)
+	if err != nil {
+		return false, err
+	}
+
+	if checkpoint == snapshotSeq {
+		return false, nil
+	}
+
+	payload, err := proto.Marshal(state)
+	if err != nil {
+		return false, fmt.Errorf("Failed to encode %s snapshot: %s", p.Name, err)
+	}
+
+	// A snapshot of another version may exist at the same seq. Replace it.
+	_, err = tx.Exec(
+		"INSERT INTO "+p.SnapshotTable+" (event_seq, payload, created_at, projection_version)"+
+			" VALUES (?, ?, ?, ?)"+
+			" ON CONFLICT (event_seq) DO UPDATE SET"+
+			" payload = excluded.payload,"+
+			" created_at = excluded.created_at,"+
+			" projection_version = excluded.projection_version",
+		checkpoint, payload, time.Now().UnixMilli(), p.Fingerprint(),
+	)
+	if err != nil {
+		return false, fmt.Errorf("Failed to save %s snapshot: %s", p.Name, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("Failed to commit %s snapshot: %s", p.Name, err)
+	}
+
+	return true, nil
+}
+
+// DeleteSnapshots deletes every snapshot in the transaction, so the state is
+// built from events on the next read.
+func (p *Projection[T]) DeleteSnapshots(tx *sql.Tx) error {
+	if _, err := tx.Exec("DELETE FROM " + p.SnapshotTable); err != nil {
+		return fmt.Errorf("Failed to delete %s snapshots: %s", p.Name, err)
+	}
+
+	return nil
+}
+
+// Number of events between progress reports of rebuilds.
+const rebuildProgressEvery = 1000
+
+// Rebuild replays every event into an initial state, then replaces every
+// snapshot with a snapshot of the state in a transaction. Reads keep using
+// the old snapshots until then. progress is called with the seq of the last
+// applied event and the latest seq at the beginning, periodically.
+// Returns the seq of the new snapshot, or 0 if there is no event.
+func (p *Projection[T]) Rebuild(db *sql.DB, progress func(done, total int)) (int, error) {
+	total, err := events.LatestSeq(db)
+	if err != nil {
+		return 0, err
+	}
+
+	state := p.New()
+	checkpoint := 0
+	applied := 0
+	for ev, err := range events.Iterate(db, events.IterateOptions{}) {
+		if err != nil {
+			return 0, err
+		}
+
+		p.Apply(ev.Message, state)
+		checkpoint = ev.Seq
+
+		applied++
+		if applied%rebuildProgressEvery == 0 {
+			progress(checkpoint, total)
+		}
+	}
+
+	ctx := context.Background()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to begin transaction for rebuilding %s projection: %s", p.Name, err)
+	}
+	defer tx.Rollback()
+
+	// Events committed during the replay.
+	for ev, err := range events.Iterate(tx, events.IterateOptions{FromSeq: checkpoint + 1}) {
+		if err != nil {
+			return 0, err
+		}
+
+		p.Apply(ev.Message, state)
+		checkpoint = ev.Seq
+	}
+
+	progress(checkpoint, max(total, checkpoint))
+
+	if _, err := tx.Exec("DELETE FROM " + p.SnapshotTable); err != nil {
+		return 0, fmt.Errorf("Failed to delete %s snapshots: %s", p.Name, err)
+	}
+
+	if checkpoint > 0 {
+		payload, err := proto.Marshal(state)
+		if err != nil {
+			return 0, fmt.Errorf("Failed to encode %s snapshot: %s", p.Name, err)
+		}
+
+		_, err = tx.Exec(
+			"INSERT INTO "+p.SnapshotTable+" (event_seq, payload, created_at, projection_version) VALUES (?, ?, ?, ?)",
+			checkpoint, payload, time.Now().UnixMilli(), p.Fingerprint(),
+		)
+		if err != nil {
+			return 0, fmt.Errorf("Failed to save %s snapshot: %s", p.Name, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("Failed to commit rebuilt %s projection: %s", p.Name, err)
+	}
+
+	return checkpoint, nil
+}
+
+// CheckSnapshots replays events from scratch and returns seqs of snapshots
+// whose state differs from the replayed one. Snapshots built by other
+// versions are not checked, as they are never read. Events that cannot be
+// decoded are skipped.
+func (p *Projection[T]) CheckSnapshots(db *sql.DB) ([]int, error) {
+	type snapshot struct {
+		seq     int
+		payload []byte
+	}
+
+	rows, err := db.Query(
+		"SELECT event_seq, payload FROM "+p.SnapshotTable+" WHERE projection_version = ? ORDER BY event_seq ASC",
+		p.Fingerprint(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list %s snapshots: %s", p.Name, err)
+	}
+
+	snapshots := []snapshot{}
+	for rows.Next() {
+		var s snapshot
+		if err := rows.Scan(&s.seq, &s.payload); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("Failed to scan %s snapshot: %s", p.Name, err)
+		}
+
+		snapshots = append(snapshots, s)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Failed to list %s snapshots: %s", p.Name, err)
+	}
+
+	mismatches := []int{}
+	state := p.New()
+
+	compare := func(s snapshot) {
+		stored := p.New()
+		if err := proto.Unmarshal(s.payload, stored); err != nil || !proto.Equal(stored, state) {
+			mismatches = append(mismatches, s.seq)
+		}
+	}
+
+	for ev, err := range events.Iterate(db, events.IterateOptions{}) {
+		// Iterate yields an event without seq only for database errors.
+		if err != nil && ev.Seq == 0 {
+			return nil, err
+		}
+
+		for len(snapshots) > 0 && snapshots[0].seq < ev.Seq {
+			compare(snapshots[0])
+			snapshots = snapshots[1:]
+		}
+
+		if err == nil {
+			p.Apply(ev.Message, state)
+		}
+	}
+
+	for _, s := range snapshots {
+		compare(s)
+	}
+
+	return mismatches, nil
+}
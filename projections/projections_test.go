@@ -0,0 +1,341 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package projections
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	_ "modernc.org/sqlite"
+
+	"pocka.jp/x/event_sourcing_user_management_poc/events"
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/event"
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/projection"
+	"pocka.jp/x/event_sourcing_user_management_poc/migrations"
+)
+
+func openDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := migrations.Apply(db); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+// userIDs collects IDs of created users.
+var userIDs = &Projection[*projection.UsersProjection]{
+	Name:          "test",
+	SnapshotTable: "users_snapshots",
+	New: func() *projection.UsersProjection {
+		return &projection.UsersProjection{}
+	},
+	Apply: func(ev proto.Message, p *projection.UsersProjection) {
+		if v, ok := ev.(*event.UserCreated); ok {
+			p.Users = append(p.Users, &projection.User{Id: v.Id})
+		}
+	},
+}
+
+func createUser(t *testing.T, db *sql.DB, id string) {
+	createUserAt(t, db, id, time.Time{})
+}
+
+func createUserAt(t *testing.T, db *sql.DB, id string, occurredAt time.Time) {
+	metadata := events.Metadata{OccurredAt: occurredAt}
+	if err := events.Insert(db, events.UserStream(id), metadata, []proto.Message{
+		&event.UserCreated{Id: proto.String(id)},
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	db := openDB(t)
+
+	p, checkpoint, err := userIDs.Get(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(p.Users) != 0 || checkpoint != 0 {
+		t.Errorf("Expected empty state at 0, got %d users at %d", len(p.Users), checkpoint)
+	}
+
+	saved, err := userIDs.SaveSnapshot(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if saved {
+		t.Error("Expected no snapshot for empty log")
+	}
+}
+
+func TestReplayAfterSnapshot(t *testing.T) {
+	db := openDB(t)
+
+	createUser(t, db, "foo")
+	createUser(t, db, "bar")
+
+	saved, err := userIDs.SaveSnapshot(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !saved {
+		t.Error("Expected a snapshot to be saved")
+	}
+
+	saved, err = userIDs.SaveSnapshot(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if saved {
+		t.Error("Expected no snapshot when nothing changed")
+	}
+
+	createUser(t, db, "baz")
+
+	p, checkpoint, err := userIDs.Get(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if checkpoint != 3 {
+		t.Errorf("Expected checkpoint 3, got %d", checkpoint)
+	}
+
+	if len(p.Users) != 3 || p.Users[2].GetId() != "baz" {
+		t.Errorf("Expected foo, bar and baz, got %v", p.Users)
+	}
+}
+
+func TestIgnoreStaleSnapshot(t *testing.T) {
+	db := openDB(t)
+
+	createUser(t, db, "foo")
+	createUser(t, db, "bar")
+
+	// Snapshot built by an older Apply, which lost "bar".
+	payload, err := proto.Marshal(&projection.UsersProjection{
+		Users: []*projection.User{{Id: proto.String("foo")}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.Exec(
+		"INSERT INTO users_snapshots (event_seq, payload, projection_version) VALUES (2, ?, 'old')",
+		payload,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, checkpoint, err := userIDs.Get(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(p.Users) != 2 || checkpoint != 2 {
+		t.Errorf("Expected 2 users at 2, got %v at %d", p.Users, checkpoint)
+	}
+
+	saved, err := userIDs.SaveSnapshot(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !saved {
+		t.Error("Expected the stale snapshot to be replaced")
+	}
+
+	var version string
+	if err := db.QueryRow("SELECT projection_version FROM users_snapshots WHERE event_seq = 2").Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+
+	if version != userIDs.Fingerprint() {
+		t.Errorf("Expected version %s, got %s", userIDs.Fingerprint(), version)
+	}
+}
+
+func TestGetAt(t *testing.T) {
+	db := openDB(t)
+
+	createUser(t, db, "foo")
+	createUser(t, db, "bar")
+
+	if _, err := userIDs.SaveSnapshot(db); err != nil {
+		t.Fatal(err)
+	}
+
+	createUser(t, db, "baz")
+
+	for _, c := range []struct {
+		seq        int
+		users      int
+		checkpoint int
+	}{
+		{seq: 0, users: 0, checkpoint: 0},
+		{seq: 1, users: 1, checkpoint: 1},
+		{seq: 2, users: 2, checkpoint: 2},
+		{seq: 3, users: 3, checkpoint: 3},
+		{seq: 10, users: 3, checkpoint: 3},
+	} {
+		p, checkpoint, err := userIDs.GetAt(db, c.seq)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(p.Users) != c.users || checkpoint != c.checkpoint {
+			t.Errorf(
+				"Expected %d users at %d for seq=%d, got %d users at %d",
+				c.users, c.checkpoint, c.seq, len(p.Users), checkpoint,
+			)
+		}
+	}
+}
+
+func TestGetAsOf(t *testing.T) {
+	db := openDB(t)
+
+	base := time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)
+
+	createUserAt(t, db, "foo", base)
+	createUserAt(t, db, "bar", base.Add(24*time.Hour))
+
+	p, checkpoint, err := userIDs.GetAsOf(db, base.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(p.Users) != 1 || p.Users[0].GetId() != "foo" || checkpoint != 1 {
+		t.Errorf("Expected foo at 1, got %v at %d", p.Users, checkpoint)
+	}
+
+	p, checkpoint, err = userIDs.GetAsOf(db, base.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(p.Users) != 0 || checkpoint != 0 {
+		t.Errorf("Expected empty state at 0, got %v at %d", p.Users, checkpoint)
+	}
+
+	// Backdated events after bar do not make the state include bar.
+	createUserAt(t, db, "baz", base.Add(time.Minute))
+
+	p, checkpoint, err = userIDs.GetAsOf(db, base.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(p.Users) != 1 || p.Users[0].GetId() != "foo" || checkpoint != 1 {
+		t.Errorf("Expected foo at 1 regardless of backdated event, got %v at %d", p.Users, checkpoint)
+	}
+}
+
+func TestRebuild(t *testing.T) {
+	db := openDB(t)
+
+	createUser(t, db, "foo")
+
+	if _, err := userIDs.SaveSnapshot(db); err != nil {
+		t.Fatal(err)
+	}
+
+	createUser(t, db, "bar")
+
+	// Corrupt the snapshot.
+	if _, err := db.Exec("UPDATE users_snapshots SET payload = NULL"); err != nil {
+		t.Fatal(err)
+	}
+
+	checkpoint, err := userIDs.Rebuild(db, func(int, int) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if checkpoint != 2 {
+		t.Errorf("Expected checkpoint 2, got %d", checkpoint)
+	}
+
+	var n int
+	if err := db.QueryRow("SELECT count(*) FROM users_snapshots").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 1 {
+		t.Errorf("Expected 1 snapshot, got %d", n)
+	}
+
+	p, _, err := userIDs.Get(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(p.Users) != 2 {
+		t.Errorf("Expected 2 users, got %v", p.Users)
+	}
+}
+
+func TestCheckSnapshots(t *testing.T) {
+	db := openDB(t)
+
+	createUser(t, db, "foo")
+
+	if _, err := userIDs.SaveSnapshot(db); err != nil {
+		t.Fatal(err)
+	}
+
+	createUser(t, db, "bar")
+
+	if _, err := userIDs.SaveSnapshot(db); err != nil {
+		t.Fatal(err)
+	}
+
+	mismatches, err := userIDs.CheckSnapshots(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mismatches) != 0 {
+		t.Errorf("Expected no mismatches, got %v", mismatches)
+	}
+
+	// Snapshot missing "bar".
+	_, err = db.Exec(
+		"UPDATE users_snapshots SET payload = (SELECT payload FROM users_snapshots WHERE event_seq = 1)" +
+			" WHERE event_seq = 2",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mismatches, err = userIDs.CheckSnapshots(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mismatches) != 1 || mismatches[0] != 2 {
+		t.Errorf("Expected a mismatch at 2, got %v", mismatches)
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package projections
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Retention decides which snapshots to keep. A snapshot is kept if any of the
+// conditions matches. The latest snapshot is always kept.
+type Retention struct {
+	// Keep this number of the latest snapshots.
+	KeepLast int
+
+	// Keep the latest snapshot of each day, for this number of days including today.
+	KeepDaily int
+}
+
+type PruneResult struct {
+	// Number of deleted snapshots.
+	Deleted int
+
+	// Total size of deleted snapshot payloads, in bytes.
+	Bytes int64
+}
+
+type snapshotInfo struct {
+	seq int
+
+	// Zero for snapshots taken before created_at column exists.
+	createdAt time.Time
+
+	size int64
+}
+
+// expired returns snapshots to delete. snapshots must be ordered from the newest.
+func (r Retention) expired(snapshots []snapshotInfo, now time.Time) []snapshotInfo {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	dailyCutoff := today.AddDate(0, 0, -(r.KeepDaily - 1))
+
+	keptDays := map[string]struct{}{}
+
+	expired := []snapshotInfo{}
+	for i, s := range snapshots {
+		keep := i == 0 || i < r.KeepLast
+
+		// The first snapshot seen for a day is the latest one of that day.
+		if r.KeepDaily > 0 && !s.createdAt.IsZero() && !s.createdAt.Before(dailyCutoff) {
+			day := s.createdAt.In(now.Location()).Format(time.DateOnly)
+			if _, ok := keptDays[day]; !ok {
+				keptDays[day] = struct{}{}
+				keep = true
+			}
+		}
+
+		if !keep {
+			expired = append(expired, s)
+		}
+	}
+
+	return expired
+}
+
+// Prune deletes snapshots built by other versions of the projection, and
+// snapshots not kept by the retention.
+func (p *Projection[T]) Prune(db *sql.DB, retention Retention, now time.Time) (PruneResult, error) {
+	ctx := context.Background()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return PruneResult{}, fmt.Errorf("Failed to begin transaction for pruning %s snapshots: %s", p.Name, err)
+	}
+	defer tx.Rollback()
+
+	result := PruneResult{}
+
+	// Snapshots built by other versions are never read, regardless of retention.
+	var staleBytes sql.NullInt64
+	err = tx.QueryRow(
+		"SELECT count(*), sum(length(payload)) FROM "+p.SnapshotTable+" WHERE projection_version IS NOT ?",
+		p.Fingerprint(),
+	).Scan(&result.Deleted, &staleBytes)
+	if err != nil {
+		return PruneResult{}, fmt.Errorf("Failed to count stale %s snapshots: %s", p.Name, err)
+	}
+	result.Bytes = staleBytes.Int64
+
+	_, err = tx.Exec("DELETE FROM "+p.SnapshotTable+" WHERE projection_version IS NOT ?", p.Fingerprint())
+	if err != nil {
+		return PruneResult{}, fmt.Errorf("Failed to delete stale %s snapshots: %s", p.Name, err)
+	}
+
+	rows, err := tx.Query(
+		"SELECT event_seq, created_at, length(payload) FROM " + p.SnapshotTable + " ORDER BY event_seq DESC",
+	)
+	if err != nil {
+		return PruneResult{}, fmt.Errorf("Failed to list %s snapshots: %s", p.Name, err)
+	}
+
+	snapshots := []snapshotInfo{}
+	for rows.Next() {
+		var s snapshotInfo
+		var createdAt sql.NullInt64
+		var size sql.NullInt64
+		if err := rows.Scan(&s.seq, &createdAt, &size); err != nil {
+			rows.Close()
+			return PruneResult{}, fmt.Errorf("Failed to scan %s snapshot: %s", p.Name, err)
+		}
+
+		if createdAt.Valid {
+			s.createdAt = time.UnixMilli(createdAt.Int64)
+		}
+		s.size = size.Int64
+
+		snapshots = append(snapshots, s)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return PruneResult{}, fmt.Errorf("Failed to list %s snapshots: %s", p.Name, err)
+	}
+
+	for _, s := range retention.expired(snapshots, now) {
+		if _, err := tx.Exec("DELETE FROM "+p.SnapshotTable+" WHERE event_seq = ?", s.seq); err != nil {
+			return PruneResult{}, fmt.Errorf("Failed to delete %s snapshot at %d: %s", p.Name, s.seq, err)
+		}
+
+		result.Deleted++
+		result.Bytes += s.size
+	}
+
+	if err := tx.Commit(); err != nil {
+		return PruneResult{}, fmt.Errorf("Failed to commit pruning %s snapshots: %s", p.Name, err)
+	}
+
+	return result, nil
+}
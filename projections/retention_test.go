@@ -0,0 +1,77 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package projections
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func expiredSeqs(r Retention, snapshots []snapshotInfo, now time.Time) []int {
+	seqs := []int{}
+	for _, s := range r.expired(snapshots, now) {
+		seqs = append(seqs, s.seq)
+	}
+
+	return seqs
+}
+
+func TestKeepLast(t *testing.T) {
+	now := time.Date(2025, 4, 10, 12, 0, 0, 0, time.UTC)
+
+	snapshots := []snapshotInfo{
+		{seq: 5, createdAt: now},
+		{seq: 4, createdAt: now},
+		{seq: 3, createdAt: now},
+		{seq: 2},
+		{seq: 1},
+	}
+
+	seqs := expiredSeqs(Retention{KeepLast: 2}, snapshots, now)
+	if !slices.Equal(seqs, []int{3, 2, 1}) {
+		t.Errorf("Expected [3 2 1], got %v", seqs)
+	}
+}
+
+func TestAlwaysKeepLatest(t *testing.T) {
+	now := time.Date(2025, 4, 10, 12, 0, 0, 0, time.UTC)
+
+	snapshots := []snapshotInfo{
+		{seq: 2},
+		{seq: 1},
+	}
+
+	seqs := expiredSeqs(Retention{}, snapshots, now)
+	if !slices.Equal(seqs, []int{1}) {
+		t.Errorf("Expected [1], got %v", seqs)
+	}
+}
+
+func TestKeepDaily(t *testing.T) {
+	now := time.Date(2025, 4, 10, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	snapshots := []snapshotInfo{
+		{seq: 7, createdAt: now},
+		{seq: 6, createdAt: now.Add(-time.Hour)},
+		{seq: 5, createdAt: now.Add(-day)},
+		{seq: 4, createdAt: now.Add(-day - time.Hour)},
+		{seq: 3, createdAt: now.Add(-2 * day)},
+		{seq: 2, createdAt: now.Add(-3 * day)},
+		{seq: 1},
+	}
+
+	// Today (7 and 6), yesterday (5 and 4) and the day before (3).
+	seqs := expiredSeqs(Retention{KeepDaily: 3}, snapshots, now)
+	if !slices.Equal(seqs, []int{6, 4, 2, 1}) {
+		t.Errorf("Expected [6 4 2 1], got %v", seqs)
+	}
+}
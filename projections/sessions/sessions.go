@@ -0,0 +1,172 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package sessions
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"math"
+	"slices"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/event"
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/projection"
+	"pocka.jp/x/event_sourcing_user_management_poc/projections"
+)
+
+var Projection = &projections.Projection[*projection.SessionsProjection]{
+	Name:          "sessions",
+	SnapshotTable: "sessions_snapshots",
+	New: func() *projection.SessionsProjection {
+		return &projection.SessionsProjection{
+			Sessions:         map[string]*projection.Session{},
+			IndexByTokenHash: map[string]string{},
+			IndexByUserId:    map[string]*projection.SessionIDs{},
+		}
+	},
+	Apply:   apply,
+	Version: "3",
+}
+
+func GetProjection(db *sql.DB) (*projection.SessionsProjection, int, error) {
+	return Projection.Get(db)
+}
+
+// HashToken returns the hash of the session token, which is stored instead of
+// the token.
+func HashToken(token string) []byte {
+	hash := sha256.Sum256([]byte(token))
+	return hash[:]
+}
+
+// FindByToken returns the session of the token, or nil if there is no such
+// session. The session may be expired: check with Active.
+func FindByToken(p *projection.SessionsProjection, token string) *projection.Session {
+	id, ok := p.IndexByTokenHash[hex.EncodeToString(HashToken(token))]
+	if !ok {
+		return nil
+	}
+
+	return p.Sessions[id]
+}
+
+// ListByUser returns sessions of the user in the order they are started.
+// Sessions may be expired: check with Active.
+func ListByUser(p *projection.SessionsProjection, userID string) []*projection.Session {
+	list := []*projection.Session{}
+	for _, id := range p.IndexByUserId[userID].GetIds() {
+		if s, ok := p.Sessions[id]; ok {
+			list = append(list, s)
+		}
+	}
+
+	return list
+}
+
+// Active reports whether the session is neither expired nor idle for too long at now.
+func Active(s *projection.Session, now time.Time) bool {
+	return now.UnixMilli() < deadline(s)
+}
+
+// removeExpired removes sessions expired at t. Times in events are used
+// instead of the clock, so replays build the same state. Sessions are scanned
+// only once t passes NextExpiryAt, so refreshes do not scan every session.
+func removeExpired(p *projection.SessionsProjection, t int64) {
+	if t < p.GetNextExpiryAt() {
+		return
+	}
+
+	next := int64(math.MaxInt64)
+	for id, s := range p.Sessions {
+		if !Active(s, time.UnixMilli(t)) {
+			remove(p, id)
+		} else {
+			next = min(next, deadline(s))
+		}
+	}
+
+	p.NextExpiryAt = proto.Int64(next)
+}
+
+// deadline returns the time the session expires at unless it is refreshed.
+// Refreshes only move the deadline forward, so NextExpiryAt stays a lower bound.
+func deadline(s *projection.Session) int64 {
+	return min(s.GetExpiresAt(), s.GetLastSeenAt()+s.GetIdleTimeoutMs())
+}
+
+func remove(p *projection.SessionsProjection, id string) {
+	s, ok := p.Sessions[id]
+	if !ok {
+		return
+	}
+
+	delete(p.IndexByTokenHash, hex.EncodeToString(s.TokenHash))
+	delete(p.Sessions, id)
+
+	if ids, ok := p.IndexByUserId[s.GetUserId()]; ok {
+		ids.Ids = slices.DeleteFunc(ids.Ids, func(v string) bool { return v == id })
+		if len(ids.Ids) == 0 {
+			delete(p.IndexByUserId, s.GetUserId())
+		}
+	}
+}
+
+func apply(ev proto.Message, p *projection.SessionsProjection) {
+	if p.Sessions == nil {
+		p.Sessions = map[string]*projection.Session{}
+	}
+
+	if p.IndexByTokenHash == nil {
+		p.IndexByTokenHash = map[string]string{}
+	}
+
+	if p.IndexByUserId == nil {
+		p.IndexByUserId = map[string]*projection.SessionIDs{}
+	}
+
+	switch v := ev.(type) {
+	case *event.SessionStarted:
+		removeExpired(p, v.GetStartedAt())
+
+		p.Sessions[v.GetSessionId()] = &projection.Session{
+			Id:            v.SessionId,
+			UserId:        v.UserId,
+			TokenHash:     v.TokenHash,
+			StartedAt:     v.StartedAt,
+			LastSeenAt:    v.StartedAt,
+			ExpiresAt:     v.ExpiresAt,
+			IdleTimeoutMs: v.IdleTimeoutMs,
+			IpAddress:     v.IpAddress,
+			UserAgent:     v.UserAgent,
+		}
+		p.IndexByTokenHash[hex.EncodeToString(v.TokenHash)] = v.GetSessionId()
+		p.NextExpiryAt = proto.Int64(min(p.GetNextExpiryAt(), deadline(p.Sessions[v.GetSessionId()])))
+
+		ids, ok := p.IndexByUserId[v.GetUserId()]
+		if !ok {
+			ids = &projection.SessionIDs{}
+			p.IndexByUserId[v.GetUserId()] = ids
+		}
+		ids.Ids = append(ids.Ids, v.GetSessionId())
+	case *event.SessionRefreshed:
+		// Sessions expired before the refresh stay expired.
+		removeExpired(p, v.GetSeenAt())
+
+		if s, ok := p.Sessions[v.GetSessionId()]; ok {
+			s.LastSeenAt = proto.Int64(max(s.GetLastSeenAt(), v.GetSeenAt()))
+		}
+	case *event.SessionEnded:
+		remove(p, v.GetSessionId())
+		removeExpired(p, v.GetEndedAt())
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package sessions
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/event"
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/projection"
+)
+
+func build(events []proto.Message) *projection.SessionsProjection {
+	var p projection.SessionsProjection
+
+	for _, e := range events {
+		apply(e, &p)
+	}
+
+	return &p
+}
+
+func started(id string, token string, at int64) *event.SessionStarted {
+	return &event.SessionStarted{
+		SessionId:     proto.String(id),
+		UserId:        proto.String("user"),
+		TokenHash:     HashToken(token),
+		StartedAt:     proto.Int64(at),
+		ExpiresAt:     proto.Int64(at + 10_000),
+		IdleTimeoutMs: proto.Int64(1_000),
+	}
+}
+
+func TestFindByToken(t *testing.T) {
+	p := build([]proto.Message{
+		started("foo", "foo-token", 0),
+		started("bar", "bar-token", 0),
+	})
+
+	if s := FindByToken(p, "foo-token"); s.GetId() != "foo" {
+		t.Errorf("Expected session \"foo\", got %v", s)
+	}
+
+	if s := FindByToken(p, "baz-token"); s != nil {
+		t.Errorf("Expected no session for unknown token, got %v", s)
+	}
+
+	if s := FindByToken(p, "foo"); s != nil {
+		t.Errorf("Expected no session for session ID, got %v", s)
+	}
+}
+
+func TestActive(t *testing.T) {
+	p := build([]proto.Message{
+		started("foo", "foo-token", 0),
+		&event.SessionRefreshed{SessionId: proto.String("foo"), SeenAt: proto.Int64(900)},
+	})
+
+	s := FindByToken(p, "foo-token")
+
+	if !Active(s, time.UnixMilli(1_500)) {
+		t.Error("Expected session to be active within idle timeout since the refresh")
+	}
+
+	if Active(s, time.UnixMilli(1_900)) {
+		t.Error("Expected session to be inactive after idle timeout")
+	}
+
+	s.LastSeenAt = proto.Int64(9_500)
+
+	if Active(s, time.UnixMilli(10_000)) {
+		t.Error("Expected session to be inactive after lifetime regardless of activity")
+	}
+}
+
+func TestEnded(t *testing.T) {
+	p := build([]proto.Message{
+		started("foo", "foo-token", 0),
+		started("bar", "bar-token", 0),
+		&event.SessionEnded{
+			SessionId: proto.String("foo"),
+			Reason:    event.SessionEnded_REASON_LOGOUT.Enum(),
+			EndedAt:   proto.Int64(100),
+		},
+	})
+
+	if s := FindByToken(p, "foo-token"); s != nil {
+		t.Errorf("Expected ended session to be removed, got %v", s)
+	}
+
+	if s := FindByToken(p, "bar-token"); s == nil {
+		t.Error("Expected other session to be kept")
+	}
+}
+
+func TestRemoveExpired(t *testing.T) {
+	p := build([]proto.Message{
+		started("foo", "foo-token", 0),
+		started("bar", "bar-token", 500),
+		// "foo" is idle for too long at the refresh of "bar".
+		&event.SessionRefreshed{SessionId: proto.String("bar"), SeenAt: proto.Int64(1_200)},
+		// Refreshing the expired session does not revive it.
+		&event.SessionRefreshed{SessionId: proto.String("foo"), SeenAt: proto.Int64(1_300)},
+	})
+
+	if len(p.Sessions) != 1 || len(p.IndexByTokenHash) != 1 {
+		t.Fatalf("Expected 1 session, got %d sessions and %d indices", len(p.Sessions), len(p.IndexByTokenHash))
+	}
+
+	if s := FindByToken(p, "bar-token"); s.GetLastSeenAt() != 1_200 {
+		t.Errorf("Expected last seen at 1200, got %v", s)
+	}
+}
+
+func TestListByUser(t *testing.T) {
+	other := started("baz", "baz-token", 0)
+	other.UserId = proto.String("other")
+
+	p := build([]proto.Message{
+		started("foo", "foo-token", 0),
+		other,
+		started("bar", "bar-token", 100),
+		&event.SessionEnded{
+			SessionId: proto.String("foo"),
+			Reason:    event.SessionEnded_REASON_REVOKED.Enum(),
+			EndedAt:   proto.Int64(200),
+		},
+		started("qux", "qux-token", 300),
+	})
+
+	list := ListByUser(p, "user")
+	if len(list) != 2 || list[0].GetId() != "bar" || list[1].GetId() != "qux" {
+		t.Errorf("Expected sessions bar and qux, got %v", list)
+	}
+
+	if list := ListByUser(p, "unknown"); len(list) != 0 {
+		t.Errorf("Expected no session, got %v", list)
+	}
+
+	// Every session of "other" is removed once it is idle for too long.
+	p = build([]proto.Message{other, started("foo", "foo-token", 5_000)})
+	if _, ok := p.IndexByUserId["other"]; ok {
+		t.Errorf("Expected index of user without sessions to be removed, got %v", p.IndexByUserId)
+	}
+}
+
+func TestNextExpiryAt(t *testing.T) {
+	p := build([]proto.Message{
+		started("foo", "foo-token", 0),
+		started("bar", "bar-token", 500),
+	})
+
+	// "foo" becomes idle at 1000.
+	if next := p.GetNextExpiryAt(); next != 1_000 {
+		t.Errorf("Expected next expiry at 1000, got %d", next)
+	}
+
+	// Refreshes before the next expiry do not scan sessions.
+	apply(&event.SessionRefreshed{SessionId: proto.String("foo"), SeenAt: proto.Int64(900)}, p)
+	if next := p.GetNextExpiryAt(); next != 1_000 {
+		t.Errorf("Expected next expiry to stay at 1000, got %d", next)
+	}
+
+	// Nothing is expired at 1000, and "bar" becomes idle at 1500 first.
+	apply(&event.SessionRefreshed{SessionId: proto.String("foo"), SeenAt: proto.Int64(1_000)}, p)
+	if next := p.GetNextExpiryAt(); next != 1_500 || len(p.Sessions) != 2 {
+		t.Errorf("Expected 2 sessions and next expiry at 1500, got %d sessions and %d", len(p.Sessions), next)
+	}
+}
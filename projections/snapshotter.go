@@ -0,0 +1,206 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package projections
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/charmbracelet/log"
+
+	"pocka.jp/x/event_sourcing_user_management_poc/events"
+)
+
+// Snapshottable is a projection whose state can be saved as a snapshot.
+// Every Projection[T] satisfies this interface.
+type Snapshottable interface {
+	// SaveSnapshot saves the current state, or does nothing if nothing changed
+	// since the latest snapshot. Returns whether a snapshot is saved.
+	SaveSnapshot(db *sql.DB) (bool, error)
+
+	// Prune deletes snapshots built by other versions of the projection, and
+	// snapshots not kept by the retention.
+	Prune(db *sql.DB, retention Retention, now time.Time) (PruneResult, error)
+
+	// Rebuild replays every event from scratch and replaces every snapshot
+	// with the result.
+	Rebuild(db *sql.DB, progress func(done, total int)) (int, error)
+
+	// CheckSnapshots returns seqs of snapshots differing from a fresh replay.
+	CheckSnapshots(db *sql.DB) ([]int, error)
+}
+
+// Policy decides when to take a snapshot of a projection.
+// Each condition is checked independently, and zero value disables it.
+type Policy struct {
+	// Take a snapshot once this number of events are committed since the
+	// last snapshot.
+	EveryEvents int
+
+	// Take a snapshot at this interval, if there are new events.
+	Interval time.Duration
+
+	// Take a snapshot when the snapshotter stops, if there are new events.
+	OnShutdown bool
+
+	// Delete old snapshots after taking a snapshot. nil keeps every snapshot.
+	Retention *Retention
+}
+
+type snapshotTarget struct {
+	name       string
+	projection Snapshottable
+	policy     Policy
+
+	// Number of events committed since the last snapshot.
+	pending int
+
+	lastSnapshotAt time.Time
+}
+
+// Snapshotter takes snapshots of projections according to their policies,
+// on a single background goroutine.
+type Snapshotter struct {
+	db      *sql.DB
+	logger  *log.Logger
+	targets []*snapshotTarget
+}
+
+func NewSnapshotter(db *sql.DB, logger *log.Logger) *Snapshotter {
+	return &Snapshotter{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// Add registers a projection. Must be called before Run.
+func (s *Snapshotter) Add(name string, projection Snapshottable, policy Policy) {
+	s.targets = append(s.targets, &snapshotTarget{
+		name:       name,
+		projection: projection,
+		policy:     policy,
+	})
+}
+
+// Run takes snapshots until ctx is canceled, then takes snapshots of projections
+// with OnShutdown policy and returns. Before watching new events, Run takes
+// snapshots of every projection so events committed while the server was
+// down are covered.
+func (s *Snapshotter) Run(ctx context.Context) error {
+	latest, err := events.LatestSeq(s.db)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, t := range s.targets {
+		t.lastSnapshotAt = now
+		s.snapshot(t, "startup")
+	}
+
+	committed := make(chan events.Event)
+
+	// The subscription has its own context, so it keeps delivering events
+	// until shutdown snapshots are done.
+	subCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sub := events.Subscribe(subCtx, s.db, events.SubscribeOptions{FromSeq: latest + 1}, func(ev events.Event) error {
+		select {
+		case committed <- ev:
+		case <-subCtx.Done():
+		}
+		return nil
+	})
+
+	var tick <-chan time.Time
+	if interval := s.tickInterval(); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-committed:
+			for _, t := range s.targets {
+				t.pending++
+
+				if t.policy.EveryEvents > 0 && t.pending >= t.policy.EveryEvents {
+					s.snapshot(t, "every events")
+				}
+			}
+		case now := <-tick:
+			for _, t := range s.targets {
+				if t.policy.Interval > 0 && t.pending > 0 && now.Sub(t.lastSnapshotAt) >= t.policy.Interval {
+					s.snapshot(t, "interval")
+				}
+			}
+		case <-sub.Done():
+			return sub.Err()
+		case <-ctx.Done():
+			// Events may be committed but not delivered yet, so pending is not
+			// checked. SaveSnapshot does nothing if there is no new event.
+			for _, t := range s.targets {
+				if t.policy.OnShutdown {
+					s.snapshot(t, "shutdown")
+				}
+			}
+
+			cancel()
+			return sub.Close()
+		}
+	}
+}
+
+// tickInterval returns the shortest interval among policies, or 0 if none
+// of them has an interval.
+func (s *Snapshotter) tickInterval() time.Duration {
+	var interval time.Duration
+	for _, t := range s.targets {
+		if t.policy.Interval > 0 && (interval == 0 || t.policy.Interval < interval) {
+			interval = t.policy.Interval
+		}
+	}
+
+	return interval
+}
+
+func (s *Snapshotter) snapshot(t *snapshotTarget, trigger string) {
+	saved, err := t.projection.SaveSnapshot(s.db)
+	if err != nil {
+		s.logger.Warnf("Failed to create %s snapshot (trigger=%s): %s", t.name, trigger, err)
+		return
+	}
+
+	t.pending = 0
+	t.lastSnapshotAt = time.Now()
+
+	if !saved {
+		return
+	}
+
+	s.logger.Debugf("Created %s snapshot (trigger=%s)", t.name, trigger)
+
+	if t.policy.Retention == nil {
+		return
+	}
+
+	result, err := t.projection.Prune(s.db, *t.policy.Retention, time.Now())
+	if err != nil {
+		s.logger.Warnf("Failed to prune %s snapshots: %s", t.name, err)
+		return
+	}
+
+	if result.Deleted > 0 {
+		s.logger.Debugf("Pruned %d %s snapshots (%d bytes)", result.Deleted, t.name, result.Bytes)
+	}
+}
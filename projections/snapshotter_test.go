@@ -0,0 +1,111 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package projections
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+func snapshotSeqs(t *testing.T, db *sql.DB) []int {
+	rows, err := db.Query("SELECT event_seq FROM users_snapshots ORDER BY event_seq ASC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	seqs := []int{}
+	for rows.Next() {
+		var seq int
+		if err := rows.Scan(&seq); err != nil {
+			t.Fatal(err)
+		}
+		seqs = append(seqs, seq)
+	}
+
+	return seqs
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSnapshotEveryEvents(t *testing.T) {
+	db := openDB(t)
+
+	// Taken at startup
+	createUser(t, db, "a")
+
+	s := NewSnapshotter(db, log.New(io.Discard))
+	s.Add("test", userIDs, Policy{EveryEvents: 2})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.Run(ctx) }()
+
+	waitFor(t, func() bool { return len(snapshotSeqs(t, db)) == 1 })
+
+	createUser(t, db, "b")
+	createUser(t, db, "c")
+	createUser(t, db, "d")
+
+	waitFor(t, func() bool { return len(snapshotSeqs(t, db)) == 2 })
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// No OnShutdown: the last event is not in snapshots.
+	seqs := snapshotSeqs(t, db)
+	if len(seqs) != 2 || seqs[0] != 1 || seqs[1] < 3 {
+		t.Errorf("Expected snapshots at 1 and 3, got %v", seqs)
+	}
+}
+
+func TestSnapshotOnShutdown(t *testing.T) {
+	db := openDB(t)
+
+	s := NewSnapshotter(db, log.New(io.Discard))
+	s.Add("test", userIDs, Policy{OnShutdown: true})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.Run(ctx) }()
+
+	createUser(t, db, "a")
+
+	// The snapshot covers the event even if the snapshotter has not received it.
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Timed out")
+	}
+
+	seqs := snapshotSeqs(t, db)
+	if len(seqs) != 1 || seqs[0] != 1 {
+		t.Errorf("Expected a snapshot at 1, got %v", seqs)
+	}
+}
@@ -0,0 +1,284 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package projections
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"pocka.jp/x/event_sourcing_user_management_poc/events"
+)
+
+// TableProjection materializes events into a regular SQL table, so the read
+// model can be queried and joined with SQL. Rows and the checkpoint in
+// "projection_checkpoints" table are updated in the same transaction.
+type TableProjection struct {
+	// Name of the projection, used as a key of the checkpoint, in logs and
+	// error messages.
+	Name string
+
+	// Table storing the read model.
+	Table string
+
+	// Version of Create and Apply. Bump this whenever they change, so the
+	// table is dropped and rebuilt from scratch.
+	Version string
+
+	// Create creates a table with the name, if not exists.
+	Create func(tx *sql.Tx, table string) error
+
+	// Apply updates rows in the table by the event.
+	Apply func(tx *sql.Tx, table string, ev events.Event) error
+}
+
+// Checkpoint returns the seq of the last event applied to the table, or 0 if
+// nothing is applied yet or the table was built by another version.
+func (p *TableProjection) Checkpoint(db *sql.DB) (int, error) {
+	return p.checkpoint(db)
+}
+
+func (p *TableProjection) checkpoint(q queryRower) (int, error) {
+	var seq int
+	var version string
+	err := q.QueryRow(
+		"SELECT event_seq, projection_version FROM projection_checkpoints WHERE name = ?", p.Name,
+	).Scan(&seq, &version)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("Failed to get checkpoint of %s projection: %s", p.Name, err)
+	}
+
+	if version != p.Version {
+		return 0, nil
+	}
+
+	return seq, nil
+}
+
+type queryRower interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// CatchUp applies events committed after the checkpoint to the table, creating
+// the table if needed. If the table was built by another version, it is dropped
+// and rebuilt. Returns the new checkpoint.
+func (p *TableProjection) CatchUp(db *sql.DB) (int, error) {
+	ctx := context.Background()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to begin transaction for %s projection: %s", p.Name, err)
+	}
+	defer tx.Rollback()
+
+	checkpoint, err := p.checkpoint(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	if checkpoint == 0 {
+		if _, err := tx.Exec("DROP TABLE IF EXISTS " + p.Table); err != nil {
+			return 0, fmt.Errorf("Failed to drop %s table: %s", p.Table, err)
+		}
+
+		if err := p.Create(tx, p.Table); err != nil {
+			return 0, fmt.Errorf("Failed to create %s table: %s", p.Table, err)
+		}
+	}
+
+	for ev, err := range events.Iterate(tx, events.IterateOptions{FromSeq: checkpoint + 1}) {
+		if err != nil {
+			return 0, err
+		}
+
+		if err := p.Apply(tx, p.Table, ev); err != nil {
+			return 0, fmt.Errorf("Failed to apply event seq=%d to %s table: %s", ev.Seq, p.Table, err)
+		}
+
+		checkpoint = ev.Seq
+	}
+
+	if err := p.saveCheckpoint(tx, checkpoint); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("Failed to commit %s projection: %s", p.Name, err)
+	}
+
+	return checkpoint, nil
+}
+
+func (p *TableProjection) saveCheckpoint(tx *sql.Tx, seq int) error {
+	_, err := tx.Exec(
+		"INSERT INTO projection_checkpoints (name, event_seq, projection_version) VALUES (?, ?, ?)"+
+			" ON CONFLICT (name) DO UPDATE SET"+
+			" event_seq = excluded.event_seq,"+
+			" projection_version = excluded.projection_version",
+		p.Name, seq, p.Version,
+	)
+	if err != nil {
+		return fmt.Errorf("Failed to save checkpoint of %s projection: %s", p.Name, err)
+	}
+
+	return nil
+}
+
+// applyEvent applies the event and saves the checkpoint in a transaction.
+// Events at or before the checkpoint are skipped.
+func (p *TableProjection) applyEvent(db *sql.DB, ev events.Event) error {
+	ctx := context.Background()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Failed to begin transaction for %s projection: %s", p.Name, err)
+	}
+	defer tx.Rollback()
+
+	checkpoint, err := p.checkpoint(tx)
+	if err != nil {
+		return err
+	}
+
+	if ev.Seq <= checkpoint {
+		return nil
+	}
+
+	if err := p.Apply(tx, p.Table, ev); err != nil {
+		return fmt.Errorf("Failed to apply event seq=%d to %s table: %s", ev.Seq, p.Table, err)
+	}
+
+	if err := p.saveCheckpoint(tx, ev.Seq); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Failed to commit %s projection: %s", p.Name, err)
+	}
+
+	return nil
+}
+
+// Start catches up the table, then applies events as they are committed
+// until ctx is canceled.
+func (p *TableProjection) Start(ctx context.Context, db *sql.DB) (*events.Subscription, error) {
+	checkpoint, err := p.CatchUp(db)
+	if err != nil {
+		return nil, err
+	}
+
+	return events.Subscribe(ctx, db, events.SubscribeOptions{FromSeq: checkpoint + 1}, func(ev events.Event) error {
+		return p.applyEvent(db, ev)
+	}), nil
+}
+
+// Rebuild replays every event into a new table, then replaces the table with
+// it in a transaction. Reads keep using the old table until then. progress is
+// called with the seq of the last applied event and the latest seq at the
+// beginning, periodically. Returns the new checkpoint.
+func (p *TableProjection) Rebuild(db *sql.DB, progress func(done, total int)) (int, error) {
+	total, err := events.LatestSeq(db)
+	if err != nil {
+		return 0, err
+	}
+
+	shadow := p.Table + "_rebuild"
+
+	err = p.inTx(db, func(tx *sql.Tx) error {
+		if _, err := tx.Exec("DROP TABLE IF EXISTS " + shadow); err != nil {
+			return fmt.Errorf("Failed to drop %s table: %s", shadow, err)
+		}
+
+		if err := p.Create(tx, shadow); err != nil {
+			return fmt.Errorf("Failed to create %s table: %s", shadow, err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Apply events in batches, so writers are not blocked for the whole replay.
+	checkpoint := 0
+	for from := 1; from <= total; from += rebuildProgressEvery {
+		err := p.inTx(db, func(tx *sql.Tx) error {
+			opts := events.IterateOptions{FromSeq: from, ToSeq: from + rebuildProgressEvery - 1}
+			for ev, err := range events.Iterate(tx, opts) {
+				if err != nil {
+					return err
+				}
+
+				if err := p.Apply(tx, shadow, ev); err != nil {
+					return fmt.Errorf("Failed to apply event seq=%d to %s table: %s", ev.Seq, shadow, err)
+				}
+
+				checkpoint = ev.Seq
+			}
+
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+
+		progress(checkpoint, total)
+	}
+
+	err = p.inTx(db, func(tx *sql.Tx) error {
+		// Events committed during the replay.
+		for ev, err := range events.Iterate(tx, events.IterateOptions{FromSeq: max(checkpoint, total) + 1}) {
+			if err != nil {
+				return err
+			}
+
+			if err := p.Apply(tx, shadow, ev); err != nil {
+				return fmt.Errorf("Failed to apply event seq=%d to %s table: %s", ev.Seq, shadow, err)
+			}
+
+			checkpoint = ev.Seq
+		}
+
+		if _, err := tx.Exec("DROP TABLE IF EXISTS " + p.Table); err != nil {
+			return fmt.Errorf("Failed to drop %s table: %s", p.Table, err)
+		}
+
+		if _, err := tx.Exec("ALTER TABLE " + shadow + " RENAME TO " + p.Table); err != nil {
+			return fmt.Errorf("Failed to rename %s table to %s: %s", shadow, p.Table, err)
+		}
+
+		return p.saveCheckpoint(tx, checkpoint)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return checkpoint, nil
+}
+
+func (p *TableProjection) inTx(db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return fmt.Errorf("Failed to begin transaction for %s projection: %s", p.Name, err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Failed to commit %s projection: %s", p.Name, err)
+	}
+
+	return nil
+}
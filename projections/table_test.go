@@ -0,0 +1,156 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package projections
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"pocka.jp/x/event_sourcing_user_management_poc/events"
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/event"
+)
+
+// userIDsTable stores IDs of created users in a table.
+func userIDsTable(version string) *TableProjection {
+	return &TableProjection{
+		Name:    "test",
+		Table:   "test_user_ids",
+		Version: version,
+		Create: func(tx *sql.Tx, table string) error {
+			_, err := tx.Exec("CREATE TABLE IF NOT EXISTS " + table + " (id TEXT)")
+			return err
+		},
+		Apply: func(tx *sql.Tx, table string, ev events.Event) error {
+			if v, ok := ev.Message.(*event.UserCreated); ok {
+				_, err := tx.Exec("INSERT INTO "+table+" (id) VALUES (?)", v.GetId())
+				return err
+			}
+			return nil
+		},
+	}
+}
+
+func countRows(t *testing.T, db *sql.DB) int {
+	var n int
+	if err := db.QueryRow("SELECT count(*) FROM test_user_ids").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+
+	return n
+}
+
+func TestTableCatchUp(t *testing.T) {
+	db := openDB(t)
+
+	createUser(t, db, "foo")
+	createUser(t, db, "bar")
+
+	p := userIDsTable("1")
+
+	checkpoint, err := p.CatchUp(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if checkpoint != 2 || countRows(t, db) != 2 {
+		t.Errorf("Expected 2 rows at 2, got %d rows at %d", countRows(t, db), checkpoint)
+	}
+
+	createUser(t, db, "baz")
+
+	// Rows must not be applied twice.
+	if _, err := p.CatchUp(db); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := countRows(t, db); n != 3 {
+		t.Errorf("Expected 3 rows, got %d", n)
+	}
+
+	// Another version rebuilds the table.
+	checkpoint, err = userIDsTable("2").CatchUp(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if checkpoint != 3 || countRows(t, db) != 3 {
+		t.Errorf("Expected 3 rows at 3, got %d rows at %d", countRows(t, db), checkpoint)
+	}
+}
+
+func TestTableStart(t *testing.T) {
+	db := openDB(t)
+
+	createUser(t, db, "foo")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p := userIDsTable("1")
+
+	sub, err := p.Start(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	createUser(t, db, "bar")
+
+	waitFor(t, func() bool {
+		checkpoint, err := p.Checkpoint(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return checkpoint == 2
+	})
+
+	if n := countRows(t, db); n != 2 {
+		t.Errorf("Expected 2 rows, got %d", n)
+	}
+}
+
+func TestTableRebuild(t *testing.T) {
+	db := openDB(t)
+
+	createUser(t, db, "foo")
+	createUser(t, db, "bar")
+
+	p := userIDsTable("1")
+
+	if _, err := p.CatchUp(db); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec("DELETE FROM test_user_ids"); err != nil {
+		t.Fatal(err)
+	}
+
+	progressed := false
+	checkpoint, err := p.Rebuild(db, func(done, total int) {
+		progressed = done == 2 && total == 2
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if checkpoint != 2 || countRows(t, db) != 2 {
+		t.Errorf("Expected 2 rows at 2, got %d rows at %d", countRows(t, db), checkpoint)
+	}
+
+	if !progressed {
+		t.Error("Expected progress to be reported")
+	}
+
+	if c, err := p.Checkpoint(db); err != nil || c != 2 {
+		t.Errorf("Expected stored checkpoint 2, got %d (%v)", c, err)
+	}
+}
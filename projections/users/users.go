@@ -10,90 +10,130 @@
 package users
 
 import (
-	"context"
 	"database/sql"
-	"fmt"
+	"slices"
+	"strings"
+	"time"
 
 	"google.golang.org/protobuf/proto"
 
-	"pocka.jp/x/event_sourcing_user_management_poc/events"
 	"pocka.jp/x/event_sourcing_user_management_poc/gen/event"
 	"pocka.jp/x/event_sourcing_user_management_poc/gen/projection"
+	"pocka.jp/x/event_sourcing_user_management_poc/projections"
 )
 
+var Projection = &projections.Projection[*projection.UsersProjection]{
+	Name:          "users",
+	SnapshotTable: "users_snapshots",
+	New: func() *projection.UsersProjection {
+		return &projection.UsersProjection{
+			Users:        []*projection.User{},
+			IndexById:    map[string]int32{},
+			IndexByEmail: map[string]int32{},
+		}
+	},
+	Apply:   apply,
+	Version: "3",
+}
+
 func GetProjection(db *sql.DB) (*projection.UsersProjection, int, error) {
-	ctx := context.Background()
+	return Projection.Get(db)
+}
 
-	var p projection.UsersProjection
+// GetProjectionAt returns users right after the event at seq.
+func GetProjectionAt(db *sql.DB, seq int) (*projection.UsersProjection, int, error) {
+	return Projection.GetAt(db, seq)
+}
 
-	tx, err := db.BeginTx(ctx, nil)
-	if err != nil {
-		return nil, 0, fmt.Errorf("Failed to begin transaction for UsersProjection: %s", err)
-	}
-	defer tx.Rollback()
+// GetProjectionAsOf returns users at the time t.
+func GetProjectionAsOf(db *sql.DB, t time.Time) (*projection.UsersProjection, int, error) {
+	return Projection.GetAsOf(db, t)
+}
 
-	var eventSeq int
-	var payload []byte
+// NormalizeEmail returns the email in the form used for lookups and
+// duplicate detection.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
 
-	err = tx.QueryRow("SELECT event_seq, payload FROM users_snapshots ORDER BY event_seq DESC LIMIT 1").Scan(&eventSeq, &payload)
-	if err == sql.ErrNoRows {
-		p = projection.UsersProjection{
-			Users: []*projection.User{},
-		}
-		eventSeq = -1
-	} else if err != nil {
-		return nil, 0, fmt.Errorf("Failed to get latest snapshot: %s", err)
-	} else {
-		if err := proto.Unmarshal(payload, &p); err != nil {
-			return nil, 0, fmt.Errorf("Failed to decode latest snapshot: %s", err)
-		}
+// FindByID returns the user with the ID, or nil if there is no such user.
+func FindByID(p *projection.UsersProjection, id string) *projection.User {
+	i, ok := p.IndexById[id]
+	if !ok {
+		return nil
 	}
 
-	stmt, err := tx.Prepare("SELECT seq, event_name, payload FROM user_events WHERE seq > ? ORDER BY seq ASC")
-	if err != nil {
-		return nil, 0, fmt.Errorf("Failed to prepare event fetching query: %s", err)
-	}
+	return p.Users[i]
+}
 
-	maxSeq := -1
-	rows, err := stmt.Query(eventSeq)
-	for rows.Next() {
-		ev, seq, err := events.ScanEvent(rows)
-		if err != nil {
-			return nil, 0, err
-		}
+// FindByEmail returns the user with the email, or nil if there is no such user.
+// When more than one user has the email, this returns the first created one.
+func FindByEmail(p *projection.UsersProjection, email string) *projection.User {
+	i, ok := p.IndexByEmail[NormalizeEmail(email)]
+	if !ok {
+		return nil
+	}
 
-		maxSeq = max(maxSeq, seq)
+	return p.Users[i]
+}
 
-		apply(ev, &p)
+// NextOwnerOfEmail returns the user the email is indexed to once the user
+// with the ID is erased, or nil if no other user has the email.
+func NextOwnerOfEmail(p *projection.UsersProjection, email string, erasedID string) *projection.User {
+	for _, user := range p.Users {
+		if user.GetId() != erasedID && NormalizeEmail(user.GetEmail()) == email {
+			return user
+		}
 	}
 
-	return &p, maxSeq, nil
+	return nil
 }
 
 func apply(ev proto.Message, p *projection.UsersProjection) {
+	if p.IndexById == nil {
+		p.IndexById = map[string]int32{}
+	}
+
+	if p.IndexByEmail == nil {
+		p.IndexByEmail = map[string]int32{}
+	}
+
 	switch v := ev.(type) {
 	case *event.UserCreated:
+		i := int32(len(p.Users))
+
 		p.Users = append(p.Users, &projection.User{
 			Id:          v.Id,
 			DisplayName: v.DisplayName,
 			Email:       v.Email,
 		})
+
+		if _, ok := p.IndexById[v.GetId()]; !ok {
+			p.IndexById[v.GetId()] = i
+		}
+
+		// Erased users have no email.
+		email := NormalizeEmail(v.GetEmail())
+		if email == "" {
+			return
+		}
+
+		if _, ok := p.IndexByEmail[email]; !ok {
+			p.IndexByEmail[email] = i
+		} else if !slices.Contains(p.DuplicateEmails, email) {
+			p.DuplicateEmails = append(p.DuplicateEmails, email)
+		}
 		return
 	case *event.PasswordLoginConfigured:
 		if v.UserId == nil {
 			return
 		}
 
-		for _, user := range p.Users {
-			if *user.Id != *v.UserId {
-				continue
-			}
-
+		if user := FindByID(p, *v.UserId); user != nil {
 			user.PasswordLogin = &projection.User_PasswordLogin{
 				Hash: v.PasswordHash,
 				Salt: v.Salt,
 			}
-			return
 		}
 		return
 	case *event.RoleAssigned:
@@ -101,35 +141,53 @@ func apply(ev proto.Message, p *projection.UsersProjection) {
 			return
 		}
 
-		for _, user := range p.Users {
-			if *user.Id != *v.UserId {
-				continue
-			}
-
+		if user := FindByID(p, *v.UserId); user != nil {
 			user.Role = v.Role
+		}
+		return
+	case *event.UserErased:
+		if v.UserId == nil {
 			return
 		}
+
+		user := FindByID(p, *v.UserId)
+		if user == nil {
+			return
+		}
+
+		email := NormalizeEmail(user.GetEmail())
+
+		user.DisplayName = nil
+		user.Email = nil
+		user.Erased = proto.Bool(true)
+
+		if email != "" {
+			reindexEmail(p, email)
+		}
 		return
 	}
 }
 
-func SaveSnapshot(db *sql.DB) error {
-	p, seq, err := GetProjection(db)
-	if err != nil {
-		return err
-	}
+// reindexEmail points the email to the first user having it, as if the users
+// were created without the erased one.
+func reindexEmail(p *projection.UsersProjection, email string) {
+	delete(p.IndexByEmail, email)
 
-	stmt, err := db.Prepare("INSERT OR ABORT INTO users_snapshots (event_seq, payload) VALUES (?, ?)")
-	if err != nil {
-		return err
-	}
+	count := 0
+	for i, user := range p.Users {
+		if NormalizeEmail(user.GetEmail()) != email {
+			continue
+		}
 
-	payload, err := proto.Marshal(p)
-	if err != nil {
-		return err
+		if count == 0 {
+			p.IndexByEmail[email] = int32(i)
+		}
+		count++
 	}
 
-	_, err = stmt.Exec(seq, payload)
-
-	return err
+	if count < 2 {
+		p.DuplicateEmails = slices.DeleteFunc(p.DuplicateEmails, func(e string) bool {
+			return e == email
+		})
+	}
 }
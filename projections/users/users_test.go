@@ -108,3 +108,66 @@ func TestWithPWLogin(t *testing.T) {
 		t.Errorf("Expected [3,4,5], got %v", p.Users[0].PasswordLogin.Salt)
 	}
 }
+
+func TestFindByEmail(t *testing.T) {
+	p := build([]proto.Message{
+		&event.UserCreated{
+			Id:    proto.String("foo"),
+			Email: proto.String("Foo@Example.com "),
+		},
+		&event.UserCreated{
+			Id:    proto.String("bar"),
+			Email: proto.String("bar@example.com"),
+		},
+		&event.UserCreated{
+			Id:    proto.String("baz"),
+			Email: proto.String("foo@example.com"),
+		},
+	})
+
+	if user := FindByEmail(p, " FOO@example.com"); user.GetId() != "foo" {
+		t.Errorf("Expected foo, got %v", user)
+	}
+
+	if user := FindByID(p, "bar"); user.GetEmail() != "bar@example.com" {
+		t.Errorf("Expected bar, got %v", user)
+	}
+
+	if user := FindByID(p, "qux"); user != nil {
+		t.Errorf("Expected no user, got %v", user)
+	}
+
+	if len(p.DuplicateEmails) != 1 || p.DuplicateEmails[0] != "foo@example.com" {
+		t.Errorf("Expected [foo@example.com] as duplicates, got %v", p.DuplicateEmails)
+	}
+}
+
+func TestErased(t *testing.T) {
+	p := build([]proto.Message{
+		&event.UserCreated{
+			Id:          proto.String("foo"),
+			DisplayName: proto.String("Foo"),
+			Email:       proto.String("foo@example.com"),
+		},
+		&event.UserCreated{
+			Id:    proto.String("bar"),
+			Email: proto.String("foo@example.com"),
+		},
+		&event.UserErased{
+			UserId: proto.String("foo"),
+		},
+	})
+
+	foo := FindByID(p, "foo")
+	if !foo.GetErased() || foo.DisplayName != nil || foo.Email != nil {
+		t.Errorf("Expected foo to be erased, got %v", foo)
+	}
+
+	if user := FindByEmail(p, "foo@example.com"); user.GetId() != "bar" {
+		t.Errorf("Expected bar, got %v", user)
+	}
+
+	if len(p.DuplicateEmails) != 0 {
+		t.Errorf("Expected no duplicates, got %v", p.DuplicateEmails)
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+// Package users_table materializes users into "users" table, for ad-hoc SQL
+// queries. HTTP handlers use the users projection instead.
+package users_table
+
+import (
+	"database/sql"
+
+	"pocka.jp/x/event_sourcing_user_management_poc/events"
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/event"
+	"pocka.jp/x/event_sourcing_user_management_poc/projections"
+)
+
+var Projection = &projections.TableProjection{
+	Name:    "users_table",
+	Table:   "users",
+	Version: "2",
+	Create:  create,
+	Apply:   apply,
+}
+
+func create(tx *sql.Tx, table string) error {
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS ` + table + ` (
+		id TEXT PRIMARY KEY,
+		-- NULL for erased users.
+		email TEXT,
+		-- NULL for erased users.
+		display_name TEXT,
+		-- Name of the role, e.g. "ROLE_ADMIN". NULL until a role is assigned.
+		role TEXT,
+		-- Unix time in milliseconds. NULL for users created before events have
+		-- occurred_at.
+		created_at INTEGER
+	)`)
+	return err
+}
+
+func apply(tx *sql.Tx, table string, ev events.Event) error {
+	switch v := ev.Message.(type) {
+	case *event.UserCreated:
+		var createdAt sql.NullInt64
+		if !ev.Metadata.OccurredAt.IsZero() {
+			createdAt = sql.NullInt64{Int64: ev.Metadata.OccurredAt.UnixMilli(), Valid: true}
+		}
+
+		// The users projection keeps the first user for duplicated IDs.
+		_, err := tx.Exec(
+			"INSERT OR IGNORE INTO "+table+" (id, email, display_name, created_at) VALUES (?, ?, ?, ?)",
+			v.GetId(), v.Email, v.DisplayName, createdAt,
+		)
+		return err
+	case *event.RoleAssigned:
+		if v.UserId == nil || v.Role == nil {
+			return nil
+		}
+
+		_, err := tx.Exec("UPDATE "+table+" SET role = ? WHERE id = ?", v.GetRole().String(), v.GetUserId())
+		return err
+	case *event.UserErased:
+		if v.UserId == nil {
+			return nil
+		}
+
+		_, err := tx.Exec("UPDATE "+table+" SET email = NULL, display_name = NULL WHERE id = ?", v.GetUserId())
+		return err
+	}
+
+	return nil
+}
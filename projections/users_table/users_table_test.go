@@ -0,0 +1,81 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package users_table
+
+import (
+	"database/sql"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	_ "modernc.org/sqlite"
+
+	"pocka.jp/x/event_sourcing_user_management_poc/events"
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/event"
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/model"
+	"pocka.jp/x/event_sourcing_user_management_poc/migrations"
+)
+
+func TestUsersTable(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	defer db.Close()
+
+	if _, err := migrations.Apply(db); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := events.Insert(db, events.UserStream("foo"), events.Metadata{}, []proto.Message{
+		&event.UserCreated{
+			Id:          proto.String("foo"),
+			DisplayName: proto.String("Foo"),
+			Email:       proto.String("foo@example.com"),
+		},
+		&event.RoleAssigned{
+			UserId: proto.String("foo"),
+			Role:   model.Role_ROLE_ADMIN.Enum(),
+		},
+		&event.UserCreated{
+			Id:          proto.String("bar"),
+			DisplayName: proto.String("Bar"),
+			Email:       proto.String("bar@example.com"),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Projection.CatchUp(db); err != nil {
+		t.Fatal(err)
+	}
+
+	var email, displayName string
+	var role sql.NullString
+	var createdAt sql.NullInt64
+	err = db.QueryRow("SELECT email, display_name, role, created_at FROM users WHERE id = 'foo'").Scan(
+		&email, &displayName, &role, &createdAt,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if email != "foo@example.com" || displayName != "Foo" || role.String != "ROLE_ADMIN" || !createdAt.Valid {
+		t.Errorf("Unexpected row: email=%s display_name=%s role=%v created_at=%v", email, displayName, role, createdAt)
+	}
+
+	if err := db.QueryRow("SELECT role FROM users WHERE id = 'bar'").Scan(&role); err != nil {
+		t.Fatal(err)
+	}
+
+	if role.Valid {
+		t.Errorf("Expected no role for bar, got %s", role.String)
+	}
+}
@@ -13,9 +13,11 @@ import (
 	"bytes"
 	"database/sql"
 	_ "embed"
+	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/charmbracelet/log"
@@ -23,15 +25,16 @@ import (
 	"google.golang.org/protobuf/proto"
 
 	"pocka.jp/x/event_sourcing_user_management_poc/auth"
+	"pocka.jp/x/event_sourcing_user_management_poc/commands"
 	"pocka.jp/x/event_sourcing_user_management_poc/events"
-	"pocka.jp/x/event_sourcing_user_management_poc/gen/event"
 	"pocka.jp/x/event_sourcing_user_management_poc/gen/model"
-	"pocka.jp/x/event_sourcing_user_management_poc/projections/initial_admin_creation_password"
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/projection"
+	"pocka.jp/x/event_sourcing_user_management_poc/projections"
 	"pocka.jp/x/event_sourcing_user_management_poc/projections/users"
 )
 
-//go:embed initial_admin_creation.html
-var initialAdminCreationHtml string
+//go:embed initial_admin_creation.html.tmpl
+var initialAdminCreationHTMLTmpl string
 
 //go:embed logged_in.html.tmpl
 var loggedInHTMLTmpl string
@@ -39,21 +42,174 @@ var loggedInHTMLTmpl string
 //go:embed login.html
 var loginHTML string
 
+//go:embed admin_users.html.tmpl
+var adminUsersHTMLTmpl string
+
+//go:embed sessions.html.tmpl
+var sessionsHTMLTmpl string
+
+type initialAdminCreationPipeline struct {
+	// Values the form was submitted with, so the user does not have to type again.
+	Username string
+	Email    string
+
+	Error string
+}
+
 type loggedInAdminPipeline struct {
 	DisplayName string
 	Role        string
+	IsAdmin     bool
+}
+
+type adminUsersPipeline struct {
+	// Value for datetime-local input. Empty unless the page is requested by time.
+	At string
+
+	// Checkpoint of the shown users.
+	Seq int
+
+	SeqRequested bool
+
+	Users []adminUsersRow
+
+	// Normalized emails used by more than one user.
+	DuplicateEmails []string
+}
+
+type adminUsersRow struct {
+	ID            string
+	DisplayName   string
+	Email         string
+	Role          string
+	PasswordLogin bool
+	Erased        bool
 }
 
-func Handler(db *sql.DB, logger *log.Logger) (http.Handler, error) {
+type sessionsPipeline struct {
+	UserID      string
+	DisplayName string
+
+	// Whether the sessions are of the viewer.
+	Own bool
+
+	Sessions []sessionsRow
+}
+
+type sessionsRow struct {
+	ID         string
+	StartedAt  string
+	LastSeenAt string
+	IPAddress  string
+	UserAgent  string
+
+	// Whether this is the session of the request.
+	Current bool
+}
+
+// Layout of times shown on pages.
+const timeLayout = "2006-01-02 15:04:05"
+
+// Layout of datetime-local input value.
+const datetimeLocalLayout = "2006-01-02T15:04"
+
+// requestMetadata returns metadata for events caused by the HTTP request.
+// Reverse proxies can set "X-Request-Id" header to correlate events with their logs.
+func requestMetadata(r *http.Request, actorID string) events.Metadata {
+	requestID := r.Header.Get("X-Request-Id")
+	if requestID == "" {
+		requestID = uuid.New().String()
+	}
+
+	return events.Metadata{
+		ActorID:       actorID,
+		CorrelationID: requestID,
+		CausationID:   requestID,
+	}
+}
+
+// findUser returns a copy of the user found by the lookup function, or nil if
+// the function returns nil. The copy is safe to use after the live projection
+// is updated.
+func findUser(
+	r *http.Request,
+	live *projections.Live[*projection.UsersProjection],
+	lookup func(*projection.UsersProjection) *projection.User,
+) (*projection.User, error) {
+	var found *projection.User
+	err := live.View(r.Context(), func(p *projection.UsersProjection, _ int) {
+		if user := lookup(p); user != nil {
+			found = proto.CloneOf(user)
+		}
+	})
+
+	return found, err
+}
+
+// findUserByID returns a copy of the user, or nil if there is no such user.
+func findUserByID(
+	r *http.Request,
+	live *projections.Live[*projection.UsersProjection],
+	id string,
+) (*projection.User, error) {
+	return findUser(r, live, func(p *projection.UsersProjection) *projection.User {
+		return users.FindByID(p, id)
+	})
+}
+
+// getInitialAdminPass returns a copy of the current initial admin creation password.
+func getInitialAdminPass(
+	r *http.Request,
+	live *projections.Live[*projection.InitialAdminCreationPassword],
+) (*projection.InitialAdminCreationPassword, error) {
+	var pass *projection.InitialAdminCreationPassword
+	err := live.View(r.Context(), func(p *projection.InitialAdminCreationPassword, _ int) {
+		pass = proto.CloneOf(p)
+	})
+
+	return pass, err
+}
+
+func Handler(
+	db *sql.DB,
+	usersLive *projections.Live[*projection.UsersProjection],
+	initialAdminPassLive *projections.Live[*projection.InitialAdminCreationPassword],
+	sessionsLive *projections.Live[*projection.SessionsProjection],
+	sessionOptions SessionOptions,
+	logger *log.Logger,
+) (http.Handler, error) {
 	mux := http.NewServeMux()
 
+	loginSessions := &sessionManager{
+		db:        db,
+		live:      sessionsLive,
+		usersLive: usersLive,
+		opts:      sessionOptions,
+		logger:    logger,
+	}
+
 	loggedInAdminHtml, err := template.New("loggedInAdminHtml").Parse(loggedInHTMLTmpl)
 	if err != nil {
 		return nil, err
 	}
 
+	adminUsersHtml, err := template.New("adminUsersHtml").Parse(adminUsersHTMLTmpl)
+	if err != nil {
+		return nil, err
+	}
+
+	initialAdminCreationHtml, err := template.New("initialAdminCreationHtml").Parse(initialAdminCreationHTMLTmpl)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionsHtml, err := template.New("sessionsHtml").Parse(sessionsHTMLTmpl)
+	if err != nil {
+		return nil, err
+	}
+
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		initialAdminPass, _, err := initial_admin_creation_password.GetProjection(db)
+		initialAdminPass, err := getInitialAdminPass(r, initialAdminPassLive)
 		if err != nil {
 			logger.Errorf("Error loading initial admin creation password: %s", err)
 			w.Header().Add("Content-Type", "text/html;charset=utf-8")
@@ -63,34 +219,26 @@ func Handler(db *sql.DB, logger *log.Logger) (http.Handler, error) {
 		}
 
 		if initialAdminPass.PasswordHash != nil {
-			fmt.Fprint(w, initialAdminCreationHtml)
+			initialAdminCreationHtml.Execute(w, initialAdminCreationPipeline{})
 			return
 		}
 
-		id, err := r.Cookie("id")
-		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
-			fmt.Fprint(w, loginHTML)
-			return
-		}
-
-		p, _, err := users.GetProjection(db)
+		_, user, err := loginSessions.current(r)
 		if err != nil {
+			logger.Errorf("Error loading session: %s", err)
 			w.Header().Add("Content-Type", "text/html;charset=utf-8")
 			w.WriteHeader(http.StatusInternalServerError)
 			fmt.Fprint(w, loginHTML)
 			return
 		}
 
-		for _, user := range p.Users {
-			// No real auth. No security.
-			if *user.Id == id.Value {
-				loggedInAdminHtml.Execute(w, loggedInAdminPipeline{
-					DisplayName: *user.DisplayName,
-					Role:        user.Role.String(),
-				})
-				return
-			}
+		if user != nil {
+			loggedInAdminHtml.Execute(w, loggedInAdminPipeline{
+				DisplayName: user.GetDisplayName(),
+				Role:        user.Role.String(),
+				IsAdmin:     user.GetRole() == model.Role_ROLE_ADMIN,
+			})
+			return
 		}
 
 		w.WriteHeader(http.StatusUnauthorized)
@@ -104,7 +252,7 @@ func Handler(db *sql.DB, logger *log.Logger) (http.Handler, error) {
 			return
 		}
 
-		initialAdminPass, _, err := initial_admin_creation_password.GetProjection(db)
+		initialAdminPass, err := getInitialAdminPass(r, initialAdminPassLive)
 		if err != nil {
 			logger.Errorf("Error loading initial admin creation password: %s", err)
 			w.Header().Add("Content-Type", "text/html;charset=utf-8")
@@ -126,68 +274,59 @@ func Handler(db *sql.DB, logger *log.Logger) (http.Handler, error) {
 		password := r.PostForm.Get("password")
 		initPassword := r.PostForm.Get("init_password")
 
+		pipeline := initialAdminCreationPipeline{Username: username, Email: email}
+
 		if username == "" || email == "" || password == "" || initPassword == "" {
+			pipeline.Error = "Fill in every field."
 			w.WriteHeader(http.StatusBadRequest)
-			fmt.Fprint(w, initialAdminCreationHtml)
+			initialAdminCreationHtml.Execute(w, pipeline)
 			return
 		}
 
 		initPwHash := auth.HashPassword(initPassword, initialAdminPass.Salt)
 		if !bytes.Equal(initialAdminPass.PasswordHash, initPwHash) {
+			pipeline.Error = "Initial user password is incorrect."
 			w.WriteHeader(http.StatusUnauthorized)
-			fmt.Fprint(w, initialAdminCreationHtml)
+			initialAdminCreationHtml.Execute(w, pipeline)
 			return
 		}
 
-		pwHash, salt := auth.HashPasswordWithRandomSalt(password)
-
 		id := uuid.New().String()
 
-		if err := events.Insert(db, []proto.Message{
-			&event.UserCreated{
-				Id:          proto.String(id),
-				DisplayName: proto.String(username),
-				Email:       proto.String(email),
-			},
-			&event.PasswordLoginConfigured{
-				UserId:       proto.String(id),
-				PasswordHash: pwHash,
-				Salt:         salt,
-			},
-			&event.RoleAssigned{
-				UserId: proto.String(id),
-				Role:   model.Role.Enum(model.Role_ROLE_ADMIN),
-			},
-		}); err != nil {
+		// There is no logged-in user yet: the person creating the initial admin
+		// becomes the admin, so the event is attributed to the new user.
+		metadata := requestMetadata(r, id)
+
+		err = commands.Handle(
+			db,
+			metadata,
+			commands.CreateUser{ID: id, DisplayName: username, Email: email},
+			commands.ConfigurePasswordLogin{ID: id, Password: password},
+			commands.AssignRole{ID: id, Role: model.Role_ROLE_ADMIN},
+		)
+		if errors.Is(err, commands.ErrEmailUsed) {
+			pipeline.Error = "This email is already used by another user."
+			w.WriteHeader(http.StatusConflict)
+			initialAdminCreationHtml.Execute(w, pipeline)
+			return
+		} else if errors.Is(err, commands.ErrInvalidCommand) {
+			pipeline.Error = "Enter a valid email address."
+			w.WriteHeader(http.StatusBadRequest)
+			initialAdminCreationHtml.Execute(w, pipeline)
+			return
+		} else if err != nil {
 			logger.Error(err)
+			pipeline.Error = "Failed to create the user. Try again later."
 			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprint(w, initialAdminCreationHtml)
+			initialAdminCreationHtml.Execute(w, pipeline)
 			return
 		}
 
-		go func() {
-			logger.Debug("Creating initial admin creation password snapshot (trigger=initial admin creation)")
-
-			if err := initial_admin_creation_password.SaveSnapshot(db); err != nil {
-				logger.Warnf("Failed to update initial admin creation password snapshot: %s", err)
-			} else {
-				logger.Debug("Created initial admin creation password snapshot (trigger=initial admin creation)")
-			}
-
-			logger.Debug("Creating snapshot (trigger=initial admin creation)")
-
-			if err := users.SaveSnapshot(db); err != nil {
-				logger.Warnf("Failed to create user snapshot: %s", err)
-			} else {
-				logger.Debug("Created snapshot (trigger=initial admin creation)")
-			}
-		}()
-
-		// This project is PoC for event sourcing. UI and security is completely out-of-scope.
-		http.SetCookie(w, &http.Cookie{
-			Name:  "id",
-			Value: id,
-		})
+		if err := loginSessions.start(w, r, id); err != nil {
+			logger.Errorf("Failed to start session: %s", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
 
 		http.Redirect(w, r, "/", http.StatusFound)
 	})
@@ -195,14 +334,6 @@ func Handler(db *sql.DB, logger *log.Logger) (http.Handler, error) {
 	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
 		r.ParseForm()
 
-		p, _, err := users.GetProjection(db)
-		if err != nil {
-			w.Header().Add("Content-Type", "text/html;charset=utf-8")
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprint(w, loginHTML)
-			return
-		}
-
 		email := r.PostForm.Get("email")
 		password := r.PostForm.Get("password")
 
@@ -212,20 +343,30 @@ func Handler(db *sql.DB, logger *log.Logger) (http.Handler, error) {
 			return
 		}
 
-		for _, user := range p.Users {
-			// No real auth. No security.
-			if *user.Email == email && user.PasswordLogin != nil {
-				hash := auth.HashPassword(password, user.PasswordLogin.Salt)
-				if bytes.Equal(user.PasswordLogin.Hash, hash) {
-					// This project is PoC for event sourcing. UI and security is completely out-of-scope.
-					http.SetCookie(w, &http.Cookie{
-						Name:  "id",
-						Value: *user.Id,
-					})
-
-					http.Redirect(w, r, "/", http.StatusFound)
+		// Hash the password outside of the lookup, so slow hashing does not
+		// block updates to the projection.
+		user, err := findUser(r, usersLive, func(p *projection.UsersProjection) *projection.User {
+			return users.FindByEmail(p, email)
+		})
+		if err != nil {
+			logger.Errorf("Error loading users: %s", err)
+			w.Header().Add("Content-Type", "text/html;charset=utf-8")
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, loginHTML)
+			return
+		}
+
+		if user != nil && user.PasswordLogin != nil {
+			hash := auth.HashPassword(password, user.PasswordLogin.Salt)
+			if bytes.Equal(user.PasswordLogin.Hash, hash) {
+				if err := loginSessions.start(w, r, user.GetId()); err != nil {
+					logger.Errorf("Failed to start session: %s", err)
+					http.Error(w, "Internal server error", http.StatusInternalServerError)
 					return
 				}
+
+				http.Redirect(w, r, "/", http.StatusFound)
+				return
 			}
 		}
 
@@ -234,14 +375,249 @@ func Handler(db *sql.DB, logger *log.Logger) (http.Handler, error) {
 		return
 	})
 
-	mux.HandleFunc("/logout", func(w http.ResponseWriter, r *http.Request) {
-		http.SetCookie(w, &http.Cookie{
-			Name:    "id",
-			Value:   "",
-			Expires: time.Now(),
-		})
+	// Lists users at the given point in time, so support can tell what
+	// the state was then. "seq" takes precedence over "at".
+	mux.HandleFunc("/admin/users", func(w http.ResponseWriter, r *http.Request) {
+		_, viewer, ok := loginSessions.requireLogin(w, r)
+		if !ok {
+			return
+		}
 
-		http.Redirect(w, r, "/", http.StatusFound)
+		if viewer.GetRole() != model.Role_ROLE_ADMIN {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		pipeline := adminUsersPipeline{}
+
+		seq := -1
+		var asOf time.Time
+		var err error
+		if v := r.URL.Query().Get("seq"); v != "" {
+			seq, err = strconv.Atoi(v)
+			if err != nil || seq < 0 {
+				http.Error(w, "Invalid seq", http.StatusBadRequest)
+				return
+			}
+
+			pipeline.SeqRequested = true
+		} else if v := r.URL.Query().Get("at"); v != "" {
+			asOf, err = time.ParseInLocation(datetimeLocalLayout, v, time.Local)
+			if err != nil {
+				asOf, err = time.Parse(time.RFC3339, v)
+			}
+			if err != nil {
+				http.Error(w, "Invalid time", http.StatusBadRequest)
+				return
+			}
+
+			pipeline.At = asOf.In(time.Local).Format(datetimeLocalLayout)
+		}
+
+		var p *projection.UsersProjection
+		var checkpoint int
+		if seq >= 0 {
+			p, checkpoint, err = users.GetProjectionAt(db, seq)
+		} else if !asOf.IsZero() {
+			p, checkpoint, err = users.GetProjectionAsOf(db, asOf)
+		} else {
+			err = usersLive.View(r.Context(), func(state *projection.UsersProjection, c int) {
+				p, checkpoint = proto.CloneOf(state), c
+			})
+		}
+		if err != nil {
+			logger.Errorf("Error loading users: %s", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		pipeline.Seq = checkpoint
+		pipeline.DuplicateEmails = p.DuplicateEmails
+		for _, user := range p.Users {
+			pipeline.Users = append(pipeline.Users, adminUsersRow{
+				ID:            user.GetId(),
+				DisplayName:   user.GetDisplayName(),
+				Email:         user.GetEmail(),
+				Role:          user.GetRole().String(),
+				PasswordLogin: user.PasswordLogin != nil,
+				Erased:        user.GetErased(),
+			})
+		}
+
+		adminUsersHtml.Execute(w, pipeline)
+	})
+
+	// Erases personal data of a user, for right-to-be-forgotten requests.
+	mux.HandleFunc("POST /admin/users/erase", func(w http.ResponseWriter, r *http.Request) {
+		_, viewer, ok := loginSessions.requireLogin(w, r)
+		if !ok {
+			return
+		}
+
+		if viewer.GetRole() != model.Role_ROLE_ADMIN {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		userID := r.PostFormValue("id")
+
+		err := commands.EraseUser(db, requestMetadata(r, viewer.GetId()), userID)
+		if errors.Is(err, commands.ErrUserNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		} else if errors.Is(err, events.ErrPlaintextPersonalData) {
+			logger.Errorf("Refused to erase user %s: %s", userID, err)
+			http.Error(w, fmt.Sprintf("Personal data of the user cannot be erased. %s", err), http.StatusConflict)
+			return
+		} else if err != nil {
+			logger.Errorf("Failed to erase user %s: %s", userID, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		logger.Infof("Erased personal data of user %s", userID)
+
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+	})
+
+	// Lists active sessions of the viewer, or of the user given by "user" for
+	// admin users.
+	mux.HandleFunc("GET /sessions", func(w http.ResponseWriter, r *http.Request) {
+		current, viewer, ok := loginSessions.requireLogin(w, r)
+		if !ok {
+			return
+		}
+
+		user := viewer
+		if id := r.URL.Query().Get("user"); id != "" && id != viewer.GetId() {
+			if viewer.GetRole() != model.Role_ROLE_ADMIN {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			found, err := findUserByID(r, usersLive, id)
+			if err != nil {
+				logger.Errorf("Error loading users: %s", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+
+			if found == nil {
+				http.Error(w, "User not found", http.StatusNotFound)
+				return
+			}
+
+			user = found
+		}
+
+		list, err := loginSessions.listByUser(r, user.GetId())
+		if err != nil {
+			logger.Errorf("Error loading sessions: %s", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		pipeline := sessionsPipeline{
+			UserID:      user.GetId(),
+			DisplayName: user.GetDisplayName(),
+			Own:         user.GetId() == viewer.GetId(),
+		}
+		for _, s := range list {
+			pipeline.Sessions = append(pipeline.Sessions, sessionsRow{
+				ID:         s.GetId(),
+				StartedAt:  time.UnixMilli(s.GetStartedAt()).Format(timeLayout),
+				LastSeenAt: time.UnixMilli(s.GetLastSeenAt()).Format(timeLayout),
+				IPAddress:  s.GetIpAddress(),
+				UserAgent:  s.GetUserAgent(),
+				Current:    s.GetId() == current.GetId(),
+			})
+		}
+
+		sessionsHtml.Execute(w, pipeline)
+	})
+
+	// Revokes a session of the viewer. Admin users can revoke sessions of any user.
+	mux.HandleFunc("POST /sessions/revoke", func(w http.ResponseWriter, r *http.Request) {
+		_, viewer, ok := loginSessions.requireLogin(w, r)
+		if !ok {
+			return
+		}
+
+		id := r.PostFormValue("id")
+
+		session, err := loginSessions.find(r, id)
+		if err != nil {
+			logger.Errorf("Error loading sessions: %s", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		// Sessions of other users are hidden from non-admin users.
+		if session == nil || (session.GetUserId() != viewer.GetId() && viewer.GetRole() != model.Role_ROLE_ADMIN) {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+
+		_, err = commands.RevokeSessions(db, requestMetadata(r, viewer.GetId()), []string{id}, time.Now())
+		if err != nil {
+			logger.Errorf("Failed to revoke session %s: %s", id, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, sessionsPath(viewer, session.GetUserId()), http.StatusSeeOther)
+	})
+
+	// Revokes every session of the user given by "user", or of the viewer,
+	// except the session of the request.
+	mux.HandleFunc("POST /sessions/revoke-others", func(w http.ResponseWriter, r *http.Request) {
+		current, viewer, ok := loginSessions.requireLogin(w, r)
+		if !ok {
+			return
+		}
+
+		userID := r.PostFormValue("user")
+		if userID == "" {
+			userID = viewer.GetId()
+		}
+
+		if userID != viewer.GetId() && viewer.GetRole() != model.Role_ROLE_ADMIN {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		list, err := loginSessions.listByUser(r, userID)
+		if err != nil {
+			logger.Errorf("Error loading sessions: %s", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		ids := []string{}
+		for _, s := range list {
+			if s.GetId() != current.GetId() {
+				ids = append(ids, s.GetId())
+			}
+		}
+
+		revoked, err := commands.RevokeSessions(db, requestMetadata(r, viewer.GetId()), ids, time.Now())
+		if err != nil {
+			logger.Errorf("Failed to revoke sessions of user %s: %s", userID, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		logger.Infof("Revoked %d sessions of user %s", revoked, userID)
+
+		http.Redirect(w, r, sessionsPath(viewer, userID), http.StatusSeeOther)
+	})
+
+	mux.HandleFunc("POST /logout", func(w http.ResponseWriter, r *http.Request) {
+		if err := loginSessions.end(w, r); err != nil {
+			logger.Errorf("Failed to end session: %s", err)
+		}
+
+		http.Redirect(w, r, "/", http.StatusSeeOther)
 	})
 
 	return mux, nil
@@ -0,0 +1,243 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package routes
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"google.golang.org/protobuf/proto"
+
+	"pocka.jp/x/event_sourcing_user_management_poc/commands"
+	"pocka.jp/x/event_sourcing_user_management_poc/events"
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/event"
+	"pocka.jp/x/event_sourcing_user_management_poc/gen/projection"
+	"pocka.jp/x/event_sourcing_user_management_poc/projections"
+	"pocka.jp/x/event_sourcing_user_management_poc/projections/sessions"
+)
+
+// Name of the cookie holding the session token.
+const sessionCookieName = "session"
+
+// SessionOptions configures login sessions.
+type SessionOptions struct {
+	// Sessions end after this duration regardless of activity.
+	Lifetime time.Duration
+
+	// Sessions end when they are not used for this duration.
+	IdleTimeout time.Duration
+
+	// Whether to set Secure attribute to the session cookie. Browsers do not
+	// send Secure cookies over plain HTTP, except to localhost.
+	SecureCookie bool
+}
+
+// sessionManager starts, looks up and ends login sessions of requests.
+type sessionManager struct {
+	db        *sql.DB
+	live      *projections.Live[*projection.SessionsProjection]
+	usersLive *projections.Live[*projection.UsersProjection]
+	opts      SessionOptions
+	logger    *log.Logger
+}
+
+// start starts a session of the user and sets the session cookie.
+func (m *sessionManager) start(w http.ResponseWriter, r *http.Request, userID string) error {
+	now := time.Now()
+
+	_, token, err := commands.StartSession(
+		m.db, requestMetadata(r, userID), userID, clientIP(r), r.UserAgent(), m.opts.Lifetime, m.opts.IdleTimeout, now,
+	)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  now.Add(m.opts.Lifetime),
+		HttpOnly: true,
+		Secure:   m.opts.SecureCookie,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return nil
+}
+
+// current returns copies of the active session of the request and its user,
+// or nils if the request has no active session. The session is refreshed
+// once a quarter of the idle timeout has passed since the last refresh, so
+// not every request writes an event.
+func (m *sessionManager) current(r *http.Request) (*projection.Session, *projection.User, error) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return nil, nil, nil
+	}
+
+	var session *projection.Session
+	err = m.live.View(r.Context(), func(p *projection.SessionsProjection, _ int) {
+		if s := sessions.FindByToken(p, cookie.Value); s != nil {
+			session = proto.CloneOf(s)
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if session == nil {
+		return nil, nil, nil
+	}
+
+	now := time.Now()
+	metadata := requestMetadata(r, session.GetUserId())
+
+	if !sessions.Active(session, now) {
+		// Record the expiry, so the session is removed from the projection.
+		err := commands.EndSession(m.db, metadata, session.GetId(), event.SessionEnded_REASON_EXPIRED, now)
+		if err != nil && !errors.Is(err, commands.ErrSessionEnded) && !errors.Is(err, events.ErrConcurrencyConflict) {
+			m.logger.Warnf("Failed to end expired session: %s", err)
+		}
+
+		return nil, nil, nil
+	}
+
+	user, err := findUserByID(r, m.usersLive, session.GetUserId())
+	if err != nil || user == nil || user.GetErased() {
+		return nil, nil, err
+	}
+
+	if now.Sub(time.UnixMilli(session.GetLastSeenAt())) >= m.opts.IdleTimeout/4 {
+		err := commands.RefreshSession(m.db, metadata, session.GetId(), now)
+		if err != nil && !errors.Is(err, events.ErrConcurrencyConflict) {
+			m.logger.Warnf("Failed to refresh session: %s", err)
+		}
+	}
+
+	return session, user, nil
+}
+
+// listByUser returns copies of active sessions of the user, in the order they
+// are started.
+func (m *sessionManager) listByUser(r *http.Request, userID string) ([]*projection.Session, error) {
+	now := time.Now()
+
+	list := []*projection.Session{}
+	err := m.live.View(r.Context(), func(p *projection.SessionsProjection, _ int) {
+		for _, s := range sessions.ListByUser(p, userID) {
+			if sessions.Active(s, now) {
+				list = append(list, proto.CloneOf(s))
+			}
+		}
+	})
+
+	return list, err
+}
+
+// find returns a copy of the session, or nil if there is no such session.
+func (m *sessionManager) find(r *http.Request, id string) (*projection.Session, error) {
+	var found *projection.Session
+	err := m.live.View(r.Context(), func(p *projection.SessionsProjection, _ int) {
+		if s, ok := p.Sessions[id]; ok {
+			found = proto.CloneOf(s)
+		}
+	})
+
+	return found, err
+}
+
+// requireLogin returns the active session of the request and its user. When
+// the request has none, responds with the login page and returns false.
+func (m *sessionManager) requireLogin(
+	w http.ResponseWriter,
+	r *http.Request,
+) (*projection.Session, *projection.User, bool) {
+	session, user, err := m.current(r)
+	if err != nil {
+		m.logger.Errorf("Error loading session: %s", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+
+	if user == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, loginHTML)
+		return nil, nil, false
+	}
+
+	return session, user, true
+}
+
+// end ends the session of the request, if any, and clears the session cookie.
+// The session is looked up by its token only, so sessions of erased users and
+// idle sessions are ended too.
+func (m *sessionManager) end(w http.ResponseWriter, r *http.Request) error {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   m.opts.SecureCookie,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return nil
+	}
+
+	var session *projection.Session
+	err = m.live.View(r.Context(), func(p *projection.SessionsProjection, _ int) {
+		if s := sessions.FindByToken(p, cookie.Value); s != nil {
+			session = proto.CloneOf(s)
+		}
+	})
+	if err != nil || session == nil {
+		return err
+	}
+
+	err = commands.EndSession(
+		m.db, requestMetadata(r, session.GetUserId()), session.GetId(), event.SessionEnded_REASON_LOGOUT, time.Now(),
+	)
+	if errors.Is(err, commands.ErrSessionEnded) {
+		return nil
+	}
+
+	return err
+}
+
+// clientIP returns the IP address of the client, or the remote address as-is
+// if it is not "host:port". Headers set by reverse proxies, such as
+// "X-Forwarded-For", are not trusted.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// sessionsPath returns the path of the page listing sessions of the user, as
+// seen by the viewer.
+func sessionsPath(viewer *projection.User, userID string) string {
+	if userID == viewer.GetId() {
+		return "/sessions"
+	}
+
+	return "/sessions?user=" + url.QueryEscape(userID)
+}
@@ -10,31 +10,95 @@
 package main
 
 import (
-	"database/sql"
-	_ "embed"
+	"context"
+	"crypto/ed25519"
+	"errors"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/charmbracelet/lipgloss"
 	"github.com/charmbracelet/log"
 	_ "modernc.org/sqlite"
 
+	"pocka.jp/x/event_sourcing_user_management_poc/commands"
+	"pocka.jp/x/event_sourcing_user_management_poc/projections"
+	"pocka.jp/x/event_sourcing_user_management_poc/projections/initial_admin_creation_password"
+	"pocka.jp/x/event_sourcing_user_management_poc/projections/sessions"
+	"pocka.jp/x/event_sourcing_user_management_poc/projections/users"
 	"pocka.jp/x/event_sourcing_user_management_poc/routes"
 	"pocka.jp/x/event_sourcing_user_management_poc/setups"
 )
 
-//go:embed init.sql
-var initSQL string
-
 var noVerbose = flag.Bool("noverbose", false, "Suppress debug logs")
 
+var dbPath = flag.String(
+	"db", inMemoryDatabase, "Path to SQLite3 database file. \":memory:\" discards every data on exit",
+)
+
+var migrateOnly = flag.Bool(
+	"migrate-only", false, "Apply pending schema migrations to the database then exit",
+)
+
+var snapshotEveryEvents = flag.Int(
+	"snapshot-every-events", 100,
+	"Take a snapshot of each projection once this number of events are committed. 0 disables",
+)
+
+var snapshotInterval = flag.Duration(
+	"snapshot-interval", time.Minute,
+	"Take a snapshot of each projection at this interval, if there are new events. 0 disables",
+)
+
+var keepSnapshots = flag.Int(
+	"keep-snapshots", 10, "Number of the latest snapshots to keep for each projection",
+)
+
+var keepDailySnapshots = flag.Int(
+	"keep-daily-snapshots", 7, "Keep the latest snapshot of each day for this number of days",
+)
+
+var shouldPruneSnapshots = flag.Bool(
+	"prune-snapshots", false, "Delete snapshots not kept by -keep-snapshots and -keep-daily-snapshots then exit",
+)
+
+var shouldCheck = flag.Bool(
+	"check", false, "Check integrity of events and snapshots then exit. Exits with 1 if problems are found",
+)
+
+var signingKeyPath = flag.String(
+	"signing-key", "", "Path to ed25519 private key (PKCS #8 PEM) to sign checkpoints of events. Empty disables signing",
+)
+
+var verifyKeyPath = flag.String(
+	"verify-key", "", "Path to ed25519 public key (PKIX PEM) to verify checkpoints with. Defaults to -signing-key's",
+)
+
+var checkpointInterval = flag.Duration(
+	"checkpoint-interval", time.Hour, "Interval of signing checkpoints of events",
+)
+
 var port = flag.Uint("port", 8080, "TCP port a web server listens to")
 
 var host = flag.String("host", "localhost", "Hostname to bind a web server to")
 
+var sessionLifetime = flag.Duration(
+	"session-lifetime", 7*24*time.Hour, "Login sessions end after this duration regardless of activity",
+)
+
+var sessionIdleTimeout = flag.Duration(
+	"session-idle-timeout", 30*time.Minute, "Login sessions end when they are not used for this duration",
+)
+
+var insecureCookies = flag.Bool(
+	"insecure-cookies", false, "Omit Secure attribute of session cookies, for plain HTTP on hosts other than localhost",
+)
+
 var shouldCreateInitAdminCreationPassword = flag.Bool(
 	"init-admin-creation-password", false, "Whether generate a password for initial admin user creation",
 )
@@ -95,13 +159,192 @@ func main() {
 		logger.SetLevel(log.DebugLevel)
 	}
 
-	db, err := sql.Open("sqlite", ":memory:")
+	db, err := openDatabase(*dbPath, logger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	if *migrateOnly {
+		logger.Info("Database schema is up to date")
+		return
+	}
+
+	snapshotRetention := projections.Retention{
+		KeepLast:  *keepSnapshots,
+		KeepDaily: *keepDailySnapshots,
+	}
+
+	if *shouldPruneSnapshots {
+		if err := pruneSnapshots(db, snapshotRetention, logger); err != nil {
+			logger.Fatal(err)
+		}
+		return
+	}
+
+	if *shouldCheck {
+		problems, err := checkIntegrity(db, logger)
+		if err != nil {
+			logger.Fatal(err)
+		}
+
+		if problems > 0 {
+			logger.Errorf("Found %d problems", problems)
+			db.Close()
+			os.Exit(1)
+		}
+
+		logger.Info("Found no problems")
+		return
+	}
+
+	switch flag.Arg(0) {
+	case "":
+	case "rebuild-projection":
+		if flag.NArg() != 2 {
+			logger.Fatal("Usage: rebuild-projection <name>")
+		}
+
+		if err := rebuildProjection(db, flag.Arg(1), logger); err != nil {
+			logger.Fatal(err)
+		}
+		return
+	case "erase-user":
+		if flag.NArg() != 2 {
+			logger.Fatal("Usage: erase-user <user id>")
+		}
+
+		if err := eraseUser(db, flag.Arg(1), logger); err != nil {
+			logger.Fatal(err)
+		}
+		return
+	case "export-events":
+		if flag.NArg() > 2 {
+			logger.Fatal("Usage: export-events [file]")
+		}
+
+		if err := exportEvents(db, flag.Arg(1), logger); err != nil {
+			logger.Fatal(err)
+		}
+		return
+	case "import-events":
+		if flag.NArg() > 2 {
+			logger.Fatal("Usage: import-events [file]")
+		}
+
+		if err := importEvents(db, flag.Arg(1), logger); err != nil {
+			logger.Fatal(err)
+		}
+		return
+	case "hash-legacy-events":
+		if err := hashLegacyEvents(db, logger); err != nil {
+			logger.Fatal(err)
+		}
+		return
+	case "verify-events":
+		var key ed25519.PublicKey
+		if *verifyKeyPath != "" {
+			key, err = loadVerifyKey(*verifyKeyPath)
+		} else if *signingKeyPath != "" {
+			var privateKey ed25519.PrivateKey
+			privateKey, err = loadSigningKey(*signingKeyPath)
+			if err == nil {
+				key, _ = privateKey.Public().(ed25519.PublicKey)
+			}
+		}
+		if err != nil {
+			logger.Fatal(err)
+		}
+
+		tampered, err := verifyEvents(db, key, logger)
+		if err != nil {
+			logger.Fatal(err)
+		}
+
+		if tampered > 0 {
+			logger.Errorf("Found %d tampered events", tampered)
+			db.Close()
+			os.Exit(1)
+		}
+
+		logger.Info("Every event matches its hash")
+		return
+	default:
+		logger.Fatalf("Unknown command %q", flag.Arg(0))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := reserveExistingEmails(db, logger); err != nil {
+		logger.Fatal(err)
+	}
+
+	if err := startSubscriptions(ctx, db, logger); err != nil {
+		logger.Fatal(err)
+	}
+
+	// HTTP handlers read projections from memory, instead of loading snapshots
+	// and replaying events on every request.
+	usersLive, err := projections.StartLive(ctx, db, users.Projection)
 	if err != nil {
-		logger.Fatalf("Opening in-memory database failed: %s\n", err)
+		logger.Fatal(err)
+	}
+	watch(logger, "users", usersLive.Subscription())
+
+	initialAdminPassLive, err := projections.StartLive(ctx, db, initial_admin_creation_password.Projection)
+	if err != nil {
+		logger.Fatal(err)
 	}
+	watch(logger, "initial_admin_creation_password", initialAdminPassLive.Subscription())
 
-	if _, err := db.Exec(initSQL); err != nil {
-		logger.Fatalf("Initialization SQL failed: %s\n", err)
+	sessionsLive, err := projections.StartLive(ctx, db, sessions.Projection)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	watch(logger, "sessions", sessionsLive.Subscription())
+
+	for _, p := range tableProjections {
+		sub, err := p.Start(ctx, db)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		watch(logger, p.Name, sub)
+	}
+
+	snapshotPolicy := projections.Policy{
+		EveryEvents: *snapshotEveryEvents,
+		Interval:    *snapshotInterval,
+		OnShutdown:  true,
+		Retention:   &snapshotRetention,
+	}
+
+	snapshotter := projections.NewSnapshotter(db, logger)
+	for _, p := range snapshotProjections {
+		snapshotter.Add(p.name, p.projection, snapshotPolicy)
+	}
+
+	snapshotterDone := make(chan struct{})
+	go func() {
+		defer close(snapshotterDone)
+
+		if err := snapshotter.Run(ctx); err != nil {
+			logger.Errorf("Snapshotter stopped: %s", err)
+		}
+	}()
+
+	signerDone := make(chan struct{})
+	if *signingKeyPath != "" {
+		key, err := loadSigningKey(*signingKeyPath)
+		if err != nil {
+			logger.Fatal(err)
+		}
+
+		go func() {
+			defer close(signerDone)
+			runCheckpointSigner(ctx, db, key, *checkpointInterval, logger)
+		}()
+	} else {
+		close(signerDone)
 	}
 
 	if *shouldCreateInitAdminCreationPassword {
@@ -118,35 +361,63 @@ func main() {
 	if *shouldCreateAlice {
 		logger.Debug("Creating admin user Alice...")
 
-		id, err := setups.CreateAlice(db, logger)
-		if err != nil {
+		id, err := setups.CreateAlice(db)
+		if errors.Is(err, commands.ErrEmailUsed) {
+			logger.Infof("Skipped creating Alice, as alice@example.com is already used")
+		} else if err != nil {
 			logger.Fatal(err)
+		} else {
+			logger.Infof("Created admin user Alice. ID=%s", id)
 		}
-
-		logger.Infof("Created admin user Alice. ID=%s", id)
 	}
 
 	if *shouldCreateBob {
 		logger.Debug("Creating viewer user Bob...")
 
-		id, err := setups.CreateBob(db, logger)
-		if err != nil {
+		id, err := setups.CreateBob(db)
+		if errors.Is(err, commands.ErrEmailUsed) {
+			logger.Infof("Skipped creating Bob, as bob@example.com is already used")
+		} else if err != nil {
 			logger.Fatal(err)
+		} else {
+			logger.Infof("Created viewer user Bob. ID=%s", id)
 		}
-
-		logger.Infof("Created viewer user Bob. ID=%s", id)
 	}
 
 	addr := fmt.Sprintf("%s:%d", *host, *port)
 
 	logger.Infof("Starting HTTP server at http://%s", addr)
 
-	handler, err := routes.Handler(db, logger)
+	sessionOptions := routes.SessionOptions{
+		Lifetime:     *sessionLifetime,
+		IdleTimeout:  *sessionIdleTimeout,
+		SecureCookie: !*insecureCookies,
+	}
+
+	handler, err := routes.Handler(db, usersLive, initialAdminPassLive, sessionsLive, sessionOptions, logger)
 	if err != nil {
 		logger.Fatal(err)
 	}
 
-	http.Handle("/", handler)
+	server := &http.Server{Addr: addr, Handler: handler}
+
+	go func() {
+		<-ctx.Done()
 
-	logger.Fatal(http.ListenAndServe(addr, nil))
+		logger.Info("Shutting down HTTP server...")
+		if err := server.Shutdown(context.Background()); err != nil {
+			logger.Errorf("Failed to shutdown HTTP server: %s", err)
+		}
+	}()
+
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		logger.Fatal(err)
+	}
+
+	<-snapshotterDone
+	<-signerDone
+
+	if err := db.Close(); err != nil {
+		logger.Errorf("Failed to close database: %s", err)
+	}
 }
@@ -14,28 +14,17 @@ import (
 	"database/sql"
 	"fmt"
 
-	"google.golang.org/protobuf/proto"
-
-	"pocka.jp/x/event_sourcing_user_management_poc/auth"
-	"pocka.jp/x/event_sourcing_user_management_poc/events"
-	"pocka.jp/x/event_sourcing_user_management_poc/gen/event"
+	"pocka.jp/x/event_sourcing_user_management_poc/commands"
 )
 
 // InitAdminCreationPassword inserts InitialAdminCreationPasswordCreated event then
-// returns the generated password. As the database resets every server starts, this
-// function does not check whether there are events in the stream. This would be
-// inefficient in real-world use cases.
+// returns the generated password. This function does not check whether there are
+// events in the stream, even when the server resumes a persistent database.
+// This would be inefficient in real-world use cases.
 func InitAdminCreationPassword(db *sql.DB) (string, error) {
 	password := rand.Text()
 
-	passwordHash, salt := auth.HashPasswordWithRandomSalt(password)
-
-	if err := events.Insert(db, []proto.Message{
-		&event.InitialAdminCreationPasswordCreated{
-			PasswordHash: passwordHash,
-			Salt:         salt,
-		},
-	}); err != nil {
+	if err := commands.CreateInitialAdminCreationPassword(db, setupMetadata(), password); err != nil {
 		return "", fmt.Errorf("Unable to create initial admin creation password: %s", err)
 	}
 
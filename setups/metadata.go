@@ -0,0 +1,22 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package setups
+
+import (
+	"github.com/google/uuid"
+
+	"pocka.jp/x/event_sourcing_user_management_poc/events"
+)
+
+// setupMetadata returns metadata for events of a setup. Setups run outside of
+// any request, so they have no actor, and each setup is a correlation unit.
+func setupMetadata() events.Metadata {
+	return events.Metadata{CorrelationID: uuid.New().String()}
+}
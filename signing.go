@@ -0,0 +1,158 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"database/sql"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/charmbracelet/log"
+
+	"pocka.jp/x/event_sourcing_user_management_poc/events"
+)
+
+// readPEM returns the first PEM block in the file.
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read key file %s: %s", path, err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("No PEM data found in %s", path)
+	}
+
+	return block, nil
+}
+
+// loadSigningKey reads an ed25519 private key in PKCS #8 PEM format, e.g. one
+// generated by "openssl genpkey -algorithm ed25519".
+func loadSigningKey(path string) (ed25519.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse private key in %s: %s", path, err)
+	}
+
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("Key in %s is not an ed25519 private key", path)
+	}
+
+	return edKey, nil
+}
+
+// loadVerifyKey reads an ed25519 public key in PKIX PEM format, e.g. one
+// generated by "openssl pkey -pubout".
+func loadVerifyKey(path string) (ed25519.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse public key in %s: %s", path, err)
+	}
+
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("Key in %s is not an ed25519 public key", path)
+	}
+
+	return edKey, nil
+}
+
+// runCheckpointSigner signs the hash of the last event at the interval, and
+// once more when ctx is canceled.
+func runCheckpointSigner(
+	ctx context.Context,
+	db *sql.DB,
+	key ed25519.PrivateKey,
+	interval time.Duration,
+	logger *log.Logger,
+) {
+	sign := func() {
+		seq, err := events.SignCheckpoint(db, key, time.Now())
+		if err != nil {
+			logger.Warnf("Failed to sign checkpoint: %s", err)
+			return
+		}
+
+		if seq > 0 {
+			logger.Debugf("Signed checkpoint at seq=%d", seq)
+		}
+	}
+
+	sign()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			sign()
+		case <-ctx.Done():
+			sign()
+			return
+		}
+	}
+}
+
+// verifyEvents logs events not matching their hash or signed checkpoints, and
+// returns the number of them. Checkpoints are verified only when key is set.
+func verifyEvents(db *sql.DB, key ed25519.PublicKey, logger *log.Logger) (int, error) {
+	tampered, err := events.VerifyChain(db)
+	if err != nil {
+		return 0, err
+	}
+
+	if key != nil {
+		checkpoints, err := events.VerifyCheckpoints(db, key)
+		if err != nil {
+			return 0, err
+		}
+
+		tampered = append(tampered, checkpoints...)
+	} else {
+		logger.Warn("Skipping checkpoint verification as no key is given")
+	}
+
+	for _, t := range tampered {
+		logger.Warn(t.Reason, "seq", t.Seq)
+	}
+
+	return len(tampered), nil
+}
+
+// hashLegacyEvents starts the hash chain from the first event. Fails if the
+// chain already exists, so tampered events are never hashed again.
+func hashLegacyEvents(db *sql.DB, logger *log.Logger) error {
+	hashed, err := events.HashLegacyEvents(db)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Added %d events inserted before the hash chain exists to the chain", hashed)
+
+	return nil
+}
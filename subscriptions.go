@@ -0,0 +1,54 @@
+// Copyright 2025 Shota FUJI
+//
+// This source code is licensed under Zero-Clause BSD License.
+// You can find a copy of the Zero-Clause BSD License at LICENSES/0BSD.txt
+// You may also obtain a copy of the Zero-Clause BSD License at
+// <https://opensource.org/license/0bsd>
+//
+// SPDX-License-Identifier: 0BSD
+
+package main
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/charmbracelet/log"
+
+	"pocka.jp/x/event_sourcing_user_management_poc/events"
+)
+
+// startSubscriptions starts in-process subscriptions reacting to events
+// committed after this call.
+func startSubscriptions(ctx context.Context, db *sql.DB, logger *log.Logger) error {
+	latest, err := events.LatestSeq(db)
+	if err != nil {
+		return err
+	}
+
+	opts := events.SubscribeOptions{FromSeq: latest + 1}
+
+	watch(logger, "tail", events.Subscribe(ctx, db, opts, func(ev events.Event) error {
+		logger.Debug(
+			"Event committed",
+			"seq", ev.Seq,
+			"name", ev.Name,
+			"stream", ev.StreamID,
+			"actor", ev.Metadata.ActorID,
+			"correlation", ev.Metadata.CorrelationID,
+		)
+		return nil
+	}))
+
+	return nil
+}
+
+func watch(logger *log.Logger, name string, sub *events.Subscription) {
+	go func() {
+		<-sub.Done()
+
+		if err := sub.Err(); err != nil {
+			logger.Errorf("Subscription %s stopped: %s", name, err)
+		}
+	}()
+}